@@ -35,33 +35,14 @@ const RecipeEditor = (props: { recipe: IRecipe | undefined }) => {
     }
 
     try {
-      // Convert title to a slug: lowercase, a-z, 0-9, and -
-      const slugify = (str: string) =>
-        str
-          .normalize("NFD") // decompose accents
-          .replace(/[\u0300-\u036f]/g, "") // remove accents
-          .toLowerCase()
-          .replace(/[åäàáâãā]/g, "aa")
-          .replace(/[æ]/g, "ae")
-          .replace(/[öòóôõōø]/g, "oe")
-          .replace(/[üúùûū]/g, "u")
-          .replace(/[éèêëē]/g, "e")
-          .replace(/[íìîïī]/g, "i")
-          .replace(/[ç]/g, "c")
-          .replace(/[ñ]/g, "n")
-          .replace(/[^a-z0-9]+/g, "-") // replace non a-z0-9 with -
-          .replace(/^-+|-+$/g, "") // trim leading/trailing -
-          .replace(/--+/g, "-"); // collapse multiple -
-
-      const id = orignalId ?? slugify(form.title ?? "");
-
+      // New recipes get their id generated by the server from the title
       const result = await client.editRecipe(
         {
           ...form,
-          id,
+          id: orignalId!,
         },
         {
-          message: `Changed ${id}`,
+          message: orignalId ? `Changed ${orignalId}` : `Added ${form.title}`,
           name: authorName!,
         }
       );
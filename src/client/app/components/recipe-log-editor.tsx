@@ -36,14 +36,16 @@ export const RecipeLogEditor = (props: { log: IRecipeLog | undefined }) => {
     }
 
     try {
-      const id = orignalId ?? Math.floor(Date.now() / 1000) + "";
+      // New logs get their id generated by the server
       const result = await client.editRecipeLog(
         {
           ...form,
-          id,
+          id: orignalId!,
         },
         {
-          message: `Changed log ${id} in recipe ${props.log?.recipeId}`,
+          message: orignalId
+            ? `Changed log ${orignalId} in recipe ${form.recipeId}`
+            : `Added log to recipe ${form.recipeId}`,
           name: authorName!,
         }
       );
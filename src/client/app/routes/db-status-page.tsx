@@ -1,12 +1,27 @@
 import { Button, Paper, Stack } from "@mui/material";
-import { useClient } from "~/server";
-import { useState } from "react";
+import { useClient, type IMergeConflict, type ISyncStatus } from "~/server";
+import { useEffect, useState } from "react";
 
 const DbStatusPage = () => {
   const client = useClient();
 
   const [error, setError] = useState<Error | null>(null);
   const [loading, setLoading] = useState(false);
+  const [status, setStatus] = useState<ISyncStatus | null>(null);
+  const [conflicts, setConflicts] = useState<IMergeConflict[]>([]);
+
+  function refreshStatus() {
+    Promise.all([client.dbStatus(), client.getMergeConflicts()])
+      .then(([status, conflicts]) => {
+        setStatus(status);
+        setConflicts(conflicts);
+      })
+      .catch((err) => {
+        setError(err);
+      });
+  }
+
+  useEffect(refreshStatus, []);
 
   function handleCommand(cmd: () => Promise<any>) {
     setLoading(true);
@@ -17,6 +32,7 @@ const DbStatusPage = () => {
       })
       .finally(() => {
         setLoading(false);
+        refreshStatus();
       });
   }
 
@@ -25,6 +41,78 @@ const DbStatusPage = () => {
       sx={{ width: "100%", maxWidth: 1000, mx: "auto", padding: 2 }}
       spacing={2}
     >
+      <h2>Status</h2>
+      {status && !status.hasRemote && <p>No remote configured</p>}
+      {status && status.hasRemote && (
+        <Paper sx={{ padding: 2 }}>
+          <p>
+            Branch {status.branch ?? "-"}: {status.ahead} ahead,{" "}
+            {status.behind} behind the remote
+          </p>
+          <p>Background sync: {status.autoSync ? "on" : "off"}</p>
+          <p>
+            Last sync:{" "}
+            {status.lastSync
+              ? new Date(status.lastSync).toLocaleString()
+              : "never"}
+          </p>
+          {status.lastError && (
+            <p>
+              Last error ({new Date(status.lastErrorAt!).toLocaleString()}):{" "}
+              {status.lastError}
+            </p>
+          )}
+          {status.nextAttempt && (
+            <p>
+              Retrying at {new Date(status.nextAttempt).toLocaleString()}
+            </p>
+          )}
+        </Paper>
+      )}
+      {conflicts.length > 0 && (
+        <>
+          <h2>Merge conflicts</h2>
+          {conflicts.map((conflict) => (
+            <Paper key={conflict.id} sx={{ padding: 2 }}>
+              <p>
+                <b>{conflict.path}</b>
+                {conflict.fields.length > 0
+                  ? ": " + conflict.fields.join(", ")
+                  : ": changed on one side and deleted on the other"}
+              </p>
+              <Stack direction={"row"} spacing={2}>
+                <Button
+                  onClick={() =>
+                    handleCommand(() =>
+                      client.resolveMergeConflict(conflict.id, "merged")
+                    )
+                  }
+                >
+                  Keep merged
+                </Button>
+                <Button
+                  onClick={() =>
+                    handleCommand(() =>
+                      client.resolveMergeConflict(conflict.id, "ours")
+                    )
+                  }
+                >
+                  Use local
+                </Button>
+                <Button
+                  onClick={() =>
+                    handleCommand(() =>
+                      client.resolveMergeConflict(conflict.id, "theirs")
+                    )
+                  }
+                >
+                  Use remote
+                </Button>
+              </Stack>
+            </Paper>
+          ))}
+        </>
+      )}
       <h2>Control</h2>
       <Stack direction={"row"} spacing={2}>
         <Button
@@ -1,5 +1,16 @@
 import { useState, useEffect, type DependencyList, useCallback } from "react";
 
+// errorMessage extracts the message from an API error response
+async function errorMessage(response: Response): Promise<string> {
+  const body = await response.text();
+  try {
+    const error = JSON.parse(body) as IApiError;
+    return `${error.message} (${error.code}, request ${error.requestId})`;
+  } catch {
+    return body;
+  }
+}
+
 export class RecipeClient {
   constructor() {}
 
@@ -10,8 +21,10 @@ export class RecipeClient {
   }
 
   async editRecipe(recipe: IRecipe, commitInfo: ICommitBody): Promise<IRecipe> {
+    // Recipes with an id are updates, otherwise the server generates the id
+    const update = recipe.id ? "&update=true" : "";
     const response = await fetch(
-      `/api/recipes?${this.commitInfoToQueryString(commitInfo)}`,
+      `/api/recipes?${this.commitInfoToQueryString(commitInfo)}${update}`,
       {
         method: "POST",
         headers: {
@@ -21,7 +34,7 @@ export class RecipeClient {
       }
     );
     if (!response.ok) {
-      throw new Error("Failed to edit recipe: " + (await response.text()));
+      throw new Error("Failed to edit recipe: " + (await errorMessage(response)));
     }
     return response.json();
   }
@@ -29,7 +42,7 @@ export class RecipeClient {
   async getRecipe(id: string): Promise<IRecipe> {
     const response = await fetch(`/api/recipes/${id}`);
     if (!response.ok) {
-      throw new Error("Failed to fetch recipe: " + (await response.text()));
+      throw new Error("Failed to fetch recipe: " + (await errorMessage(response)));
     }
     return response.json();
   }
@@ -38,7 +51,7 @@ export class RecipeClient {
     const response = await fetch(`/api/recipes/${id}/history`);
     if (!response.ok) {
       throw new Error(
-        "Failed to fetch recipe history: " + (await response.text())
+        "Failed to fetch recipe history: " + (await errorMessage(response))
       );
     }
     return response.json();
@@ -48,7 +61,7 @@ export class RecipeClient {
     const response = await fetch(`/api/recipes/${id}/logs`);
     if (!response.ok) {
       throw new Error(
-        "Failed to fetch recipe logs: " + (await response.text())
+        "Failed to fetch recipe logs: " + (await errorMessage(response))
       );
     }
     return response.json();
@@ -58,12 +71,14 @@ export class RecipeClient {
     recipe: IRecipeLog,
     commitInfo: ICommitBody
   ): Promise<IRecipeLog> {
+    // Logs with an id are updates, otherwise the server generates the id
+    const url = recipe.id
+      ? `/api/recipes/${recipe.recipeId}/logs/${recipe.id}`
+      : `/api/recipes/${recipe.recipeId}/logs`;
     const response = await fetch(
-      `/api/recipes/${recipe.recipeId}/logs?${this.commitInfoToQueryString(
-        commitInfo
-      )}`,
+      `${url}?${this.commitInfoToQueryString(commitInfo)}`,
       {
-        method: "POST",
+        method: recipe.id ? "PUT" : "POST",
         headers: {
           "Content-Type": "application/json",
         },
@@ -72,7 +87,7 @@ export class RecipeClient {
     );
     if (!response.ok) {
       throw new Error(
-        "Failed to create recipe log: " + (await response.text())
+        "Failed to create recipe log: " + (await errorMessage(response))
       );
     }
     return response.json();
@@ -82,7 +97,7 @@ export class RecipeClient {
     const response = await fetch(`/api/recipes/${recipeId}/logs/${logId}`);
     if (!response.ok) {
       throw new Error(
-        "Failed to fetch recipe logs: " + (await response.text())
+        "Failed to fetch recipe logs: " + (await errorMessage(response))
       );
     }
     return response.json();
@@ -103,15 +118,16 @@ export class RecipeClient {
     );
     if (!response.ok) {
       throw new Error(
-        "Failed to delete recipe log: " + (await response.text())
+        "Failed to delete recipe log: " + (await errorMessage(response))
       );
     }
   }
 
-  async getRecipes(): Promise<IRecipe[]> {
-    const response = await fetch(`/api/recipes`);
+  async getRecipes(): Promise<Pick<IRecipe, "id" | "title">[]> {
+    // The list only shows titles, so the rest of the recipes are left out
+    const response = await fetch(`/api/recipes?fields=id,title`);
     if (!response.ok) {
-      throw new Error("Failed to get recipes: " + (await response.text()));
+      throw new Error("Failed to get recipes: " + (await errorMessage(response)));
     }
     return response.json();
   }
@@ -121,7 +137,36 @@ export class RecipeClient {
       method: "POST",
     });
     if (!response.ok) {
-      throw new Error("Failed to push database: " + (await response.text()));
+      throw new Error("Failed to push database: " + (await errorMessage(response)));
+    }
+  }
+
+  async dbStatus(): Promise<ISyncStatus> {
+    const response = await fetch(`/api/db/status`);
+    if (!response.ok) {
+      throw new Error("Failed to get database status: " + (await errorMessage(response)));
+    }
+    return await response.json();
+  }
+
+  async getMergeConflicts(): Promise<IMergeConflict[]> {
+    const response = await fetch(`/api/db/conflicts`);
+    if (!response.ok) {
+      throw new Error("Failed to get merge conflicts: " + (await errorMessage(response)));
+    }
+    return await response.json();
+  }
+
+  async resolveMergeConflict(
+    id: string,
+    take: "ours" | "theirs" | "merged"
+  ): Promise<void> {
+    const response = await fetch(
+      `/api/db/conflicts/${id}/resolve?take=${take}`,
+      { method: "POST" }
+    );
+    if (!response.ok) {
+      throw new Error("Failed to resolve merge conflict: " + (await errorMessage(response)));
     }
   }
 
@@ -130,7 +175,7 @@ export class RecipeClient {
       method: "POST",
     });
     if (!response.ok) {
-      throw new Error("Failed to pull database: " + (await response.text()));
+      throw new Error("Failed to pull database: " + (await errorMessage(response)));
     }
   }
 }
@@ -143,6 +188,7 @@ export interface IRecipeIngredient {
   name: string;
   quantity: number;
   unit: string;
+  unscalable?: boolean;
 }
 
 export interface IRecipe {
@@ -150,6 +196,28 @@ export interface IRecipe {
   title: string;
   description: string;
   ingredients: Array<IRecipeIngredient>;
+  steps: Array<IRecipeStep> | undefined;
+  yield?: {
+    amount: number;
+    unit: string;
+  };
+  archived?: boolean;
+  previousIds?: string[];
+  tags?: string[];
+  forkedFrom?: {
+    recipeId: string;
+    commit?: string;
+  };
+}
+
+export interface IRecipeStep {
+  text: string;
+  durationMinutes?: number;
+  temperature?: {
+    value: number;
+    unit: string;
+  };
+  ingredients?: string[];
 }
 
 export interface IRecipeLog {
@@ -157,7 +225,14 @@ export interface IRecipeLog {
   recipeId: string;
   description: string;
   actualIngredients: IRecipeIngredient[] | undefined;
+  promotedCommit?: string;
+  rating?: number;
+  dinerRatings?: Record<string, number>;
+  wouldMakeAgain?: boolean;
+  cookTimeMinutes?: number;
+  tags?: string[];
   commit: ICommit | undefined;
+  createdCommit: ICommit | undefined;
 }
 
 export interface ICommitBody {
@@ -179,3 +254,37 @@ export interface ICommit {
   message: string;
   hash: string;
 }
+
+export interface ISyncStatus {
+  hasRemote: boolean;
+  autoSync: boolean;
+  branch?: string;
+  ahead: number;
+  behind: number;
+  lastSync?: string;
+  lastError?: string;
+  lastErrorAt?: string;
+  nextAttempt?: string;
+  conflicts: number;
+}
+
+export interface IMergeConflict {
+  id: string;
+  path: string;
+  recipeId: string;
+  logId?: string;
+  fields: string[];
+  base: unknown;
+  ours: unknown;
+  theirs: unknown;
+  merged: unknown;
+  oursCommit: string;
+  theirsCommit: string;
+}
+
+export interface IApiError {
+  code: string;
+  message: string;
+  requestId: string;
+  errors?: { field: string; message: string }[];
+}
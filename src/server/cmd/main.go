@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"log/slog"
 
@@ -11,7 +14,7 @@ import (
 )
 
 var cfg *config.Config
-var db *database.RecipeDatabase
+var db database.Store
 
 func mustLoadConfig() *config.Config {
 	configFile := os.Getenv("CONFIG_FILE")
@@ -31,7 +34,7 @@ func mustLoadConfig() *config.Config {
 
 func main() {
 	cfg = mustLoadConfig()
-	database, err := database.New(cfg.Git)
+	database, err := database.Open(cfg)
 	if err != nil {
 		slog.Error("Failed to initialize database", "err", err)
 		os.Exit(1)
@@ -40,8 +43,14 @@ func main() {
 
 	webserver := webserver.New(cfg, db)
 
-	err = webserver.ListenAndServe()
+	// Stop on Ctrl-C or when the service manager asks, so the database is closed properly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = webserver.ListenAndServe(ctx)
 
+	if closeErr := db.Close(); closeErr != nil {
+		slog.Error("Failed to close database", "err", closeErr)
+	}
 	if err != nil {
 		slog.Error("Failed to start web server", "err", err)
 		os.Exit(1)
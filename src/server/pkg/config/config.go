@@ -5,23 +5,88 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type GitConfig struct {
-	Repository  string `yaml:"repository"`
-	Remote      string `yaml:"remote"`
-	SshKeyPath  string `yaml:"sshKeyPath"`
-	CommitEmail string `yaml:"commitEmail"`
+	Repository string `yaml:"repository"`
+	Remote     string `yaml:"remote"`
+
+	// SSH remotes authenticate with the key in SshKeyPath, or with the SSH agent if SshAgent is
+	// set or no key is given. Host keys are checked against KnownHostsPath, or the user's
+	// known_hosts files if it is empty.
+	SshUser              string `yaml:"sshUser"`
+	SshKeyPath           string `yaml:"sshKeyPath"`
+	SshKeyPassphrase     string `yaml:"sshKeyPassphrase"`
+	SshKeyPassphraseFile string `yaml:"sshKeyPassphraseFile"`
+	SshAgent             bool   `yaml:"sshAgent"`
+	KnownHostsPath       string `yaml:"knownHostsPath"`
+
+	// HTTPS remotes authenticate with HttpUsername and HttpPassword, or with a personal access
+	// token. Secrets can also be read from a file, e.g. a mounted secret.
+	HttpUsername     string `yaml:"httpUsername"`
+	HttpPassword     string `yaml:"httpPassword"`
+	HttpPasswordFile string `yaml:"httpPasswordFile"`
+	HttpToken        string `yaml:"httpToken"`
+	HttpTokenFile    string `yaml:"httpTokenFile"`
+
+	CommitEmail string     `yaml:"commitEmail"`
+	Sync        SyncConfig `yaml:"sync"`
+}
+
+// SyncConfig controls syncing with the remote in the background
+type SyncConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PullInterval is how often to pull from the remote, 5 minutes by default
+	PullInterval time.Duration `yaml:"pullInterval"`
+	// PushDelay is how long to wait after a commit before pushing, so a burst of commits
+	// is pushed at once. 10 seconds by default.
+	PushDelay time.Duration `yaml:"pushDelay"`
+	// MaxBackoff caps the wait between retries while the remote is unreachable, 30 minutes by default
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// Storage backends selectable in StorageConfig.Backend
+const (
+	StorageGit       = "git"
+	StorageMemory    = "memory"
+	StorageDirectory = "directory"
+)
+
+type StorageConfig struct {
+	// Backend is "git" (the default), "memory" for a git repository that is lost on restart,
+	// or "directory" for plain files without history
+	Backend string `yaml:"backend"`
+	// Directory holds the recipes when using the directory backend
+	Directory string `yaml:"directory"`
+}
+
+// AttachmentsConfig controls files attached to recipes
+type AttachmentsConfig struct {
+	// MaxSize is the largest file that can be attached, in bytes. 20 MiB by default.
+	MaxSize int64 `yaml:"maxSize"`
+	// MaxDimension is the number of pixels images and log photos are scaled down to fit within,
+	// 2048 by default
+	MaxDimension int `yaml:"maxDimension"`
+	// Store is a directory to keep attachments in outside of the git repository, so large files
+	// do not bloat its history. The recipe then only holds a pointer with the hash of the file.
+	// Attachments are kept in the repository when it is empty. The directory backend ignores it.
+	Store string `yaml:"store"`
+	// StoreMinSize is the size from which attachments go to Store, in bytes. All attachments go
+	// there if it is 0.
+	StoreMinSize int64 `yaml:"storeMinSize"`
 }
 
 type Config struct {
 	Server struct {
 		Port string `yaml:"port"`
 	} `yaml:"server"`
-	Git      GitConfig `yaml:"git"`
-	Frontend struct {
+	Storage     StorageConfig     `yaml:"storage"`
+	Git         GitConfig         `yaml:"git"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+	Frontend    struct {
 		EnableProxy bool `yaml:"enable_proxy"`
 	} `yaml:"frontend"`
 }
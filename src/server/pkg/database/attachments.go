@@ -0,0 +1,372 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jonasmh/recipetracker/pkg/config"
+	"github.com/jonasmh/recipetracker/pkg/images"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// pointerExtension marks files in the repository that point to an attachment in the attachment store
+const pointerExtension = ".ref"
+
+// documentExtensions are the types of attachments besides images, with the extension they are stored with
+var documentExtensions = map[string]string{
+	"application/pdf": ".pdf",
+}
+
+func attachmentsPath(recipeId string) string {
+	return recipesPath + recipeId + "/attachments/"
+}
+
+// attachmentPointer is stored in the repository in place of an attachment kept in the attachment store
+type attachmentPointer struct {
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// blobStore keeps attachments outside of the repository, in files named by the hash of their
+// content. Files are never removed, as earlier versions of recipes may still point to them.
+type blobStore struct {
+	dir     string
+	minSize int64
+}
+
+// newBlobStore returns nil if no attachment store is configured
+func newBlobStore(cfg config.AttachmentsConfig) (*blobStore, error) {
+	if cfg.Store == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Store, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create attachment store: %w", err)
+	}
+	return &blobStore{dir: cfg.Store, minSize: cfg.StoreMinSize}, nil
+}
+
+func (b *blobStore) path(hash string) string {
+	return filepath.Join(b.dir, hash[:2], hash)
+}
+
+// keeps reports whether attachments of the size go to the store
+func (b *blobStore) keeps(size int64) bool {
+	return b != nil && size >= b.minSize
+}
+
+func (b *blobStore) put(hash string, data []byte) error {
+	filePath := b.path(hash)
+	if _, err := os.Stat(filePath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// get reads the attachment, checking that it has not been changed since it was stored
+func (b *blobStore) get(hash string) ([]byte, error) {
+	if b == nil {
+		return nil, fmt.Errorf("%w: %s, no attachment store is configured", ErrAttachmentMissing, hash)
+	}
+	if len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentMissing, hash)
+	}
+	data, err := os.ReadFile(b.path(hash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrAttachmentMissing, hash)
+	} else if err != nil {
+		return nil, err
+	}
+	if contentHash(data) != hash {
+		return nil, fmt.Errorf("attachment %s in the attachment store does not match its hash", hash)
+	}
+	return data, nil
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// attachmentType detects the type of the attachment from its content
+func attachmentType(data []byte) (contentType, extension string, err error) {
+	if images.IsImage(data) {
+		contentType, extension, err := images.Sniff(data)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %s", ErrInvalidAttachment, err)
+		}
+		return contentType, extension, nil
+	}
+	contentType = http.DetectContentType(data)
+	if extension, ok := documentExtensions[contentType]; ok {
+		return contentType, extension, nil
+	}
+	return "", "", fmt.Errorf("%w: unsupported type %s, must be an image or a PDF", ErrInvalidAttachment, contentType)
+}
+
+func attachmentContentType(extension string) string {
+	if contentType := images.ContentType(extension); contentType != "" {
+		return contentType
+	}
+	for contentType, ext := range documentExtensions {
+		if ext == extension {
+			return contentType
+		}
+	}
+	return ""
+}
+
+// attachmentFiles lists the attachments of a recipe with their file names, ordered by id
+func attachmentFiles(fs billy.Filesystem, recipeId string) ([]models.Attachment, []string, error) {
+	dirInfo, err := fs.ReadDir(attachmentsPath(recipeId))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	sort.Slice(dirInfo, func(i, j int) bool { return dirInfo[i].Name() < dirInfo[j].Name() })
+
+	attachments := make([]models.Attachment, 0, len(dirInfo))
+	names := make([]string, 0, len(dirInfo))
+	for _, file := range dirInfo {
+		name := strings.TrimSuffix(file.Name(), pointerExtension)
+		extension := path.Ext(name)
+		contentType := attachmentContentType(extension)
+		if file.IsDir() || contentType == "" {
+			continue
+		}
+
+		attachment := models.Attachment{
+			Id:          strings.TrimSuffix(name, extension),
+			RecipeId:    recipeId,
+			ContentType: contentType,
+			Size:        file.Size(),
+			External:    name != file.Name(),
+		}
+		if attachment.External {
+			pointer, err := readPointer(fs, attachmentsPath(recipeId)+file.Name())
+			if err != nil {
+				return nil, nil, err
+			}
+			attachment.Size = pointer.Size
+		}
+		attachments = append(attachments, attachment)
+		names = append(names, file.Name())
+	}
+	return attachments, names, nil
+}
+
+func listAttachments(fs billy.Filesystem, recipeId string) ([]models.Attachment, error) {
+	attachments, _, err := attachmentFiles(fs, recipeId)
+	return attachments, err
+}
+
+func readPointer(fs billy.Filesystem, filePath string) (attachmentPointer, error) {
+	var pointer attachmentPointer
+	data, err := util.ReadFile(fs, filePath)
+	if err != nil {
+		return pointer, err
+	}
+	if err := json.Unmarshal(data, &pointer); err != nil {
+		return pointer, fmt.Errorf("invalid attachment pointer %s: %w", filePath, err)
+	}
+	return pointer, nil
+}
+
+// findAttachment returns the attachment and the path of its file, or of its pointer if it is external
+func findAttachment(fs billy.Filesystem, recipeId, id string) (models.Attachment, string, error) {
+	attachments, names, err := attachmentFiles(fs, recipeId)
+	if err != nil {
+		return models.Attachment{}, "", err
+	}
+	for i, attachment := range attachments {
+		if attachment.Id == id {
+			return attachment, attachmentsPath(recipeId) + names[i], nil
+		}
+	}
+	return models.Attachment{}, "", fmt.Errorf("%w: %s/%s", ErrAttachmentNotFound, recipeId, id)
+}
+
+func readAttachment(fs billy.Filesystem, blobs *blobStore, recipeId, id string) (models.Attachment, []byte, error) {
+	attachment, filePath, err := findAttachment(fs, recipeId, id)
+	if err != nil {
+		return attachment, nil, err
+	}
+	if attachment.External {
+		data, err := blobs.get(attachment.Id)
+		return attachment, data, err
+	}
+	data, err := util.ReadFile(fs, filePath)
+	return attachment, data, err
+}
+
+// newAttachment checks the file, and returns the attachment with the path and content to store
+// in the recipe. ok is false if the recipe already has the file.
+func newAttachment(fs billy.Filesystem, blobs *blobStore, recipeId string, data []byte) (attachment models.Attachment, filePath string, content []byte, ok bool, err error) {
+	contentType, extension, err := attachmentType(data)
+	if err != nil {
+		return attachment, "", nil, false, err
+	}
+
+	hash := contentHash(data)
+	if existing, _, err := findAttachment(fs, recipeId, hash); err == nil {
+		return existing, "", nil, false, nil
+	}
+
+	attachment = models.Attachment{
+		Id:          hash,
+		RecipeId:    recipeId,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		External:    blobs.keeps(int64(len(data))),
+	}
+	filePath = attachmentsPath(recipeId) + hash + extension
+	if !attachment.External {
+		return attachment, filePath, data, true, nil
+	}
+
+	if err := blobs.put(hash, data); err != nil {
+		return attachment, "", nil, false, err
+	}
+	content, err = json.MarshalIndent(attachmentPointer{Sha256: hash, Size: attachment.Size}, "", "  ")
+	return attachment, filePath + pointerExtension, content, true, err
+}
+
+func (db *RecipeDatabase) GetAttachments(recipeId string) ([]models.Attachment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.getRecipe(recipeId); err != nil {
+		return nil, err
+	}
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	return listAttachments(worktree.Filesystem, recipeId)
+}
+
+func (db *RecipeDatabase) GetAttachment(recipeId, id string) (models.Attachment, []byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+	return readAttachment(worktree.Filesystem, db.blobs, recipeId, id)
+}
+
+// AddAttachment commits the file to the recipe directory. Files at least as large as configured
+// for the attachment store are kept there instead, and the recipe only gets a pointer to them.
+func (db *RecipeDatabase) AddAttachment(recipeId string, data []byte, commitMessage, authorName string) (models.Attachment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.getRecipe(recipeId); err != nil {
+		return models.Attachment{}, err
+	}
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	attachment, filePath, content, ok, err := newAttachment(worktree.Filesystem, db.blobs, recipeId, data)
+	if err != nil || !ok {
+		return attachment, err
+	}
+	if err := applyFile(worktree, filePath, content); err != nil {
+		return attachment, err
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Add attachment %s to recipe %s", attachment.Id[:12], recipeId)
+	}
+	if _, err := db.commit(worktree, commitMessage, authorName); err != nil {
+		return attachment, err
+	}
+	return attachment, nil
+}
+
+// DeleteAttachment removes the attachment from the recipe. An external attachment stays in the
+// attachment store, where earlier versions of the recipe can still find it.
+func (db *RecipeDatabase) DeleteAttachment(recipeId, id string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	_, filePath, err := findAttachment(worktree.Filesystem, recipeId, id)
+	if err != nil {
+		return err
+	}
+	if _, err := worktree.Remove(filePath); err != nil {
+		return err
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Delete attachment %s from recipe %s", id[:min(len(id), 12)], recipeId)
+	}
+	_, err = db.commit(worktree, commitMessage, authorName)
+	return err
+}
+
+func (s *DirectoryStore) GetAttachments(recipeId string) ([]models.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.getRecipe(recipeId); err != nil {
+		return nil, err
+	}
+	return listAttachments(s.fs, recipeId)
+}
+
+func (s *DirectoryStore) GetAttachment(recipeId, id string) (models.Attachment, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return readAttachment(s.fs, nil, recipeId, id)
+}
+
+// AddAttachment stores the file in the recipe directory. Without history there is nothing to keep
+// small, so the attachment store is not used.
+func (s *DirectoryStore) AddAttachment(recipeId string, data []byte, commitMessage, authorName string) (models.Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getRecipe(recipeId); err != nil {
+		return models.Attachment{}, err
+	}
+	attachment, filePath, content, ok, err := newAttachment(s.fs, nil, recipeId, data)
+	if err != nil || !ok {
+		return attachment, err
+	}
+	return attachment, s.writeBytes(filePath, content)
+}
+
+func (s *DirectoryStore) DeleteAttachment(recipeId, id string, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, filePath, err := findAttachment(s.fs, recipeId, id)
+	if err != nil {
+		return err
+	}
+	return s.fs.Remove(filePath)
+}
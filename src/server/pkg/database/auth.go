@@ -0,0 +1,167 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/jonasmh/recipetracker/pkg/config"
+)
+
+// defaultTokenUser is sent as the user name with a token, for hosts like GitHub that expect
+// tokens as the password of basic auth
+const defaultTokenUser = "x-access-token"
+
+// credentials are the secrets used to authenticate with the remote, read once at startup
+type credentials struct {
+	sshPassphrase string
+	httpPassword  string
+	httpToken     string
+}
+
+// loadCredentials reads the secrets from the config, or from the files they point to
+func loadCredentials(config config.GitConfig) (creds credentials, err error) {
+	if creds.sshPassphrase, err = secret(config.SshKeyPassphrase, config.SshKeyPassphraseFile); err != nil {
+		return creds, fmt.Errorf("failed to read SSH key passphrase: %w", err)
+	}
+	if creds.httpPassword, err = secret(config.HttpPassword, config.HttpPasswordFile); err != nil {
+		return creds, fmt.Errorf("failed to read HTTP password: %w", err)
+	}
+	if creds.httpToken, err = secret(config.HttpToken, config.HttpTokenFile); err != nil {
+		return creds, fmt.Errorf("failed to read HTTP token: %w", err)
+	}
+	return creds, nil
+}
+
+func secret(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		// The error names the file, never its contents
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// authMethod returns how to authenticate with the remote, depending on its protocol
+func (db *RecipeDatabase) authMethod() (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(db.config.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid remote URL", ErrInvalid)
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		return db.sshAuth(endpoint)
+	case "http", "https":
+		return db.httpAuth(endpoint)
+	default:
+		return nil, nil
+	}
+}
+
+func (db *RecipeDatabase) sshAuth(endpoint *transport.Endpoint) (transport.AuthMethod, error) {
+	user := db.config.SshUser
+	if user == "" {
+		user = endpoint.User
+	}
+	if user == "" {
+		user = "git"
+	}
+
+	// Only connect to hosts listed in known_hosts, with the key algorithms listed there
+	var knownHostsFiles []string
+	if db.config.KnownHostsPath != "" {
+		knownHostsFiles = append(knownHostsFiles, db.config.KnownHostsPath)
+	}
+	knownHosts, err := ssh.NewKnownHostsDb(knownHostsFiles...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	port := endpoint.Port
+	if port == 0 {
+		port = 22
+	}
+	hostKeys := ssh.HostKeyCallbackHelper{
+		HostKeyCallback:   knownHosts.HostKeyCallback(),
+		HostKeyAlgorithms: knownHosts.HostKeyAlgorithms(net.JoinHostPort(endpoint.Host, strconv.Itoa(port))),
+	}
+
+	if db.config.SshAgent || db.config.SshKeyPath == "" {
+		auth, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		}
+		auth.HostKeyCallbackHelper = hostKeys
+		return auth, nil
+	}
+
+	auth, err := ssh.NewPublicKeysFromFile(user, db.config.SshKeyPath, db.credentials.sshPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSH key %s: %s", db.config.SshKeyPath, db.redact(err))
+	}
+	auth.HostKeyCallbackHelper = hostKeys
+	return auth, nil
+}
+
+func (db *RecipeDatabase) httpAuth(endpoint *transport.Endpoint) (transport.AuthMethod, error) {
+	var auth *http.BasicAuth
+	switch {
+	case db.credentials.httpToken != "":
+		user := db.config.HttpUsername
+		if user == "" {
+			user = defaultTokenUser
+		}
+		auth = &http.BasicAuth{Username: user, Password: db.credentials.httpToken}
+	case db.config.HttpUsername != "" || db.credentials.httpPassword != "":
+		auth = &http.BasicAuth{Username: db.config.HttpUsername, Password: db.credentials.httpPassword}
+	}
+
+	if (auth != nil || endpoint.Password != "") && endpoint.Protocol != "https" {
+		return nil, errors.New("refusing to send credentials over plain HTTP, use an https remote")
+	}
+	if auth == nil {
+		// Without configured credentials, go-git uses any user and password in the URL
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// secrets lists everything that must not be shown in logs or error messages
+func (db *RecipeDatabase) secrets() []string {
+	secrets := []string{db.credentials.sshPassphrase, db.credentials.httpPassword, db.credentials.httpToken}
+	if u, err := url.Parse(db.config.Remote); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			secrets = append(secrets, password)
+		}
+	}
+	return secrets
+}
+
+// redact returns the message of err with any secret replaced
+func (db *RecipeDatabase) redact(err error) string {
+	message := err.Error()
+	for _, secret := range db.secrets() {
+		if secret != "" {
+			message = strings.ReplaceAll(message, secret, "xxxxx")
+		}
+	}
+	return message
+}
+
+// redactedRemote returns the remote URL with any password hidden, for logging
+func redactedRemote(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return remote
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"container/heap"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jonasmh/recipetracker/pkg/config"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+const (
+	defaultPullInterval = 5 * time.Minute
+	defaultPushDelay    = 10 * time.Second
+	defaultMaxBackoff   = 30 * time.Minute
+	// minBackoff is the wait after the first failure, doubling with every failure after that
+	minBackoff = 10 * time.Second
+)
+
+// syncState records the outcome of the latest push and pull, for Status
+type syncState struct {
+	mu          sync.Mutex
+	lastSync    time.Time
+	lastError   error
+	lastErrorAt time.Time
+	nextAttempt time.Time
+}
+
+func (s *syncState) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.lastError = err
+		s.lastErrorAt = time.Now()
+		return
+	}
+	s.lastSync = time.Now()
+	s.lastError = nil
+	s.nextAttempt = time.Time{}
+}
+
+func (s *syncState) setNextAttempt(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextAttempt = t
+}
+
+func (s *syncState) fill(status *models.SyncStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.lastSync.IsZero() {
+		status.LastSync = s.lastSync.Format(time.RFC3339)
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+		status.LastErrorAt = s.lastErrorAt.Format(time.RFC3339)
+	}
+	if !s.nextAttempt.IsZero() {
+		status.NextAttempt = s.nextAttempt.Format(time.RFC3339)
+	}
+}
+
+// autoSync pulls from the remote on an interval, and pushes shortly after each commit.
+// While the remote fails, retries back off exponentially.
+type autoSync struct {
+	db          *RecipeDatabase
+	config      config.SyncConfig
+	failures    int
+	nextAttempt time.Time
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func startAutoSync(db *RecipeDatabase) *autoSync {
+	cfg := db.config.Sync
+	if cfg.PullInterval <= 0 {
+		cfg.PullInterval = defaultPullInterval
+	}
+	if cfg.PushDelay <= 0 {
+		cfg.PushDelay = defaultPushDelay
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+
+	a := &autoSync{
+		db:     db,
+		config: cfg,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	slog.Info("Starting background sync", "pullInterval", cfg.PullInterval, "pushDelay", cfg.PushDelay)
+	go a.run()
+	return a
+}
+
+func (a *autoSync) run() {
+	defer close(a.done)
+
+	pull := time.NewTimer(0)
+	defer pull.Stop()
+	push := time.NewTimer(a.config.PushDelay)
+	push.Stop()
+	defer push.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-a.db.changed:
+			// Don't push sooner than a pending retry
+			push.Reset(max(a.config.PushDelay, time.Until(a.nextAttempt)))
+		case <-push.C:
+			if err := a.db.Push(); err != nil {
+				if errors.Is(err, ErrRemoteDiverged) {
+					// The remote has new commits, which must be merged before pushing
+					pull.Reset(0)
+				}
+				push.Reset(a.retry(err))
+				continue
+			}
+			a.succeeded()
+		case <-pull.C:
+			if err := a.db.Pull(); err != nil {
+				pull.Reset(a.retry(err))
+				continue
+			}
+			a.succeeded()
+			pull.Reset(a.config.PullInterval)
+		}
+	}
+}
+
+// retry returns how long to wait before trying again after a failure
+func (a *autoSync) retry(err error) time.Duration {
+	a.failures++
+	delay := a.config.MaxBackoff
+	if a.failures < 20 {
+		delay = min(minBackoff<<(a.failures-1), a.config.MaxBackoff)
+	}
+	a.nextAttempt = time.Now().Add(delay)
+	a.db.syncState.setNextAttempt(a.nextAttempt)
+
+	slog.Warn("Sync with remote failed", "err", err, "failures", a.failures, "retryIn", delay)
+	return delay
+}
+
+func (a *autoSync) succeeded() {
+	a.failures = 0
+	a.nextAttempt = time.Time{}
+}
+
+func (a *autoSync) close() {
+	close(a.stop)
+	<-a.done
+}
+
+// notifyChanged tells the background sync that a commit was made. It never blocks,
+// as a pending notification already covers the new commit.
+func (db *RecipeDatabase) notifyChanged() {
+	select {
+	case db.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Status compares the local branch with the remote branch as of the last fetch, and reports
+// the outcome of the latest sync
+func (db *RecipeDatabase) Status() (models.SyncStatus, error) {
+	status := models.SyncStatus{
+		HasRemote: db.config.Remote != "",
+		AutoSync:  db.autoSync != nil,
+	}
+	if !status.HasRemote {
+		return status, nil
+	}
+	db.syncState.fill(&status)
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	head, err := db.repo.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return status, nil // Empty repository
+		}
+		return status, err
+	}
+	status.Branch = head.Name().Short()
+
+	remoteHash := plumbing.ZeroHash
+	remoteRef, err := db.repo.Reference(plumbing.NewRemoteReferenceName("origin", status.Branch), true)
+	if err == nil {
+		remoteHash = remoteRef.Hash()
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return status, err
+	}
+
+	status.Ahead, status.Behind, err = aheadBehind(db.repo, head.Hash(), remoteHash)
+	if err != nil {
+		return status, err
+	}
+
+	if conflicts, err := db.conflicts.ReadDir("."); err == nil {
+		status.Conflicts = len(conflicts)
+	}
+	return status, nil
+}
+
+// aheadBehind counts the commits only reachable from local, and only reachable from remote. It
+// walks back from both, newest first, and stops once every commit left to walk is reachable from
+// both and older than the commits reachable from one, so only the commits since the merge base
+// are read. Like git, it assumes commits are not older than their parents.
+func aheadBehind(repo *git.Repository, local, remote plumbing.Hash) (ahead, behind int, err error) {
+	if local == remote {
+		return 0, 0, nil
+	}
+
+	const fromLocal, fromRemote = 1, 2
+	const fromBoth = fromLocal | fromRemote
+	reachable := make(map[plumbing.Hash]int)
+	queue := &commitQueue{}
+	// shared counts the commits in the queue reachable from both
+	shared := 0
+	mark := func(commit *object.Commit, from int) {
+		if reachable[commit.Hash]|from == reachable[commit.Hash] {
+			return
+		}
+		reachable[commit.Hash] |= from
+		if reachable[commit.Hash] == fromBoth {
+			shared++
+		}
+		heap.Push(queue, queuedCommit{commit, reachable[commit.Hash]})
+	}
+	for hash, from := range map[plumbing.Hash]int{local: fromLocal, remote: fromRemote} {
+		if hash.IsZero() {
+			continue
+		}
+		commit, err := repo.CommitObject(hash)
+		if err != nil {
+			return 0, 0, err
+		}
+		mark(commit, from)
+	}
+
+	// oldest is the time of the oldest commit walked that was reachable from one only. Commits
+	// made in the same second may be in either order, so those are walked too.
+	var oldest time.Time
+	for queue.Len() > 0 {
+		if queue.Len() == shared && (*queue)[0].commit.Committer.When.Before(oldest) {
+			break
+		}
+		next := heap.Pop(queue).(queuedCommit)
+		if next.from == fromBoth {
+			shared--
+		}
+		if next.from != reachable[next.commit.Hash] {
+			continue // Queued again since, with more marks
+		}
+		if next.from != fromBoth {
+			oldest = next.commit.Committer.When
+		}
+		err := next.commit.Parents().ForEach(func(parent *object.Commit) error {
+			mark(parent, next.from)
+			return nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for _, from := range reachable {
+		switch from {
+		case fromLocal:
+			ahead++
+		case fromRemote:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// queuedCommit is a commit to walk, with how it was reachable when it was queued
+type queuedCommit struct {
+	commit *object.Commit
+	from   int
+}
+
+// commitQueue is a heap of commits, newest first
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].commit.Committer.When.After(q[j].commit.Committer.When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
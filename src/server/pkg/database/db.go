@@ -2,18 +2,53 @@ package database
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"log/slog"
 
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
 	gitconfig "github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/jonasmh/recipetracker/pkg/config"
 )
 
+// lockFileName is the file inside .git used to keep two processes from using the same repository
+const lockFileName = "recipetracker.lock"
+
+// conflictsDirName is the directory inside .git holding unresolved merge conflicts. They are kept
+// out of the worktree, so they are not committed and pushed to other clones.
+const conflictsDirName = "recipetracker-conflicts"
+
 type RecipeDatabase struct {
 	config config.GitConfig
+	repo   *git.Repository
+	// mu serializes writes to the repository, while allowing concurrent readers
+	mu sync.RWMutex
+	// lock keeps other processes from using the same repository
+	lock *fileLock
+	// index answers reads from memory, and is kept in sync with HEAD under mu
+	index *recipeIndex
+	// changed receives a value after every commit, for the background sync to push
+	changed   chan struct{}
+	syncState syncState
+	autoSync  *autoSync
+	// credentials are kept out of config, so that config can be logged
+	credentials credentials
+	// blobs keeps large attachments out of the repository, if configured
+	blobs *blobStore
+	// conflicts holds the unresolved merge conflicts, outside the tracked tree
+	conflicts billy.Filesystem
 }
 
 func New(config config.GitConfig) (*RecipeDatabase, error) {
@@ -35,102 +70,234 @@ func New(config config.GitConfig) (*RecipeDatabase, error) {
 		}
 	}
 
-	if config.Remote != "" {
-		repo, err := git.PlainOpen(config.Repository)
-		if err != nil {
-			return nil, errors.Join(err, errors.New("failed to open git repository for remote setup"))
-		}
-		_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
-			Name: "origin",
-			URLs: []string{config.Remote},
-		})
-		if err != nil {
-			if errors.Is(err, git.ErrRemoteExists) {
-				cfg, err := repo.Config()
-				if err != nil {
-					return nil, errors.Join(err, errors.New("failed to get git config"))
-				}
-
-				cfg.Remotes["origin"].URLs = []string{config.Remote}
-				if err := repo.Storer.SetConfig(cfg); err != nil {
-					return nil, errors.Join(err, errors.New("failed to update origin remote URL"))
-				}
-				slog.Info("Updated remote origin URL", "url", config.Remote)
-			} else {
-				return nil, errors.Join(err, errors.New("failed to create origin remote"))
-			}
-		} else {
-			slog.Info("Created remote origin", "url", config.Remote)
-		}
+	credentials, err := loadCredentials(config)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := acquireFileLock(filepath.Join(config.Repository, ".git", lockFileName))
+	if err != nil {
+		return nil, errors.Join(err, errors.New("failed to lock git repository, is another recipetracker using it?"))
 	}
 
-	return &RecipeDatabase{
-		config: config,
-	}, nil
+	repo, err := git.PlainOpen(config.Repository)
+	if err != nil {
+		lock.release()
+		return nil, errors.Join(err, errors.New("failed to open git repository"))
+	}
+
+	if err := setupRemote(repo, config); err != nil {
+		lock.release()
+		return nil, err
+	}
+
+	index, err := buildIndex(repo)
+	if err != nil {
+		lock.release()
+		return nil, errors.Join(err, errors.New("failed to index git repository"))
+	}
+
+	db := &RecipeDatabase{
+		config:      config,
+		repo:        repo,
+		lock:        lock,
+		index:       index,
+		changed:     make(chan struct{}, 1),
+		credentials: credentials,
+		conflicts:   osfs.New(filepath.Join(config.Repository, ".git", conflictsDirName)),
+	}
+	db.startAutoSync()
+	return db, nil
 }
 
-func (db *RecipeDatabase) Push() error {
-	repo, err := git.PlainOpen(db.config.Repository)
+// NewInMemory creates a database backed by a git repository in memory, which is lost when the
+// process exits. Useful for tests and demos.
+func NewInMemory(config config.GitConfig) (*RecipeDatabase, error) {
+	credentials, err := loadCredentials(config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	sshKey, err := db.loadSshkey()
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
 	if err != nil {
-		return err
+		return nil, errors.Join(err, errors.New("failed to initialize in-memory git repository"))
+	}
+	slog.Info("Created in-memory git repository")
+
+	if err := setupRemote(repo, config); err != nil {
+		return nil, err
 	}
 
-	slog.Info("Pushing to remote", "remote", db.config.Remote)
+	db := &RecipeDatabase{
+		config:      config,
+		repo:        repo,
+		index:       newRecipeIndex(),
+		changed:     make(chan struct{}, 1),
+		credentials: credentials,
+		conflicts:   memfs.New(),
+	}
+	db.startAutoSync()
+	return db, nil
+}
 
-	if err := repo.Push(&git.PushOptions{
-		Auth: sshKey,
-	}); err != nil {
-		return err
+func (db *RecipeDatabase) startAutoSync() {
+	if db.config.Sync.Enabled && db.config.Remote != "" {
+		db.autoSync = startAutoSync(db)
+	}
+}
+
+// setupRemote points the origin remote at the configured remote
+func setupRemote(repo *git.Repository, config config.GitConfig) error {
+	if config.Remote == "" {
+		return nil
+	}
+
+	_, err := repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{config.Remote},
+	})
+	if err == nil {
+		slog.Info("Created remote origin", "url", redactedRemote(config.Remote))
+		return nil
+	}
+	if !errors.Is(err, git.ErrRemoteExists) {
+		return errors.Join(err, errors.New("failed to create origin remote"))
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return errors.Join(err, errors.New("failed to get git config"))
 	}
 
+	cfg.Remotes["origin"].URLs = []string{config.Remote}
+	if err := repo.Storer.SetConfig(cfg); err != nil {
+		return errors.Join(err, errors.New("failed to update origin remote URL"))
+	}
+	slog.Info("Updated remote origin URL", "url", redactedRemote(config.Remote))
 	return nil
 }
 
-func (db *RecipeDatabase) Pull() error {
-	repo, err := git.PlainOpen(db.config.Repository)
+// Close stops the background sync, and releases the lock on the repository
+func (db *RecipeDatabase) Close() error {
+	if db.autoSync != nil {
+		db.autoSync.close()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.lock.release()
+}
+
+func (db *RecipeDatabase) Push() error {
+	if db.config.Remote == "" {
+		return ErrNoRemote
+	}
+
+	// Pushing only reads the repository, but must not see a half-made commit
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	auth, err := db.authMethod()
 	if err != nil {
 		return err
 	}
-	worktree, err := repo.Worktree()
+
+	head, err := db.repo.Head()
 	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil // Nothing to push yet
+		}
+		return err
+	}
+
+	slog.Info("Pushing to remote", "remote", redactedRemote(db.config.Remote))
+
+	// Only push the checked out branch, as experiment branches are local
+	branch := head.Name()
+	if err := db.repo.Push(&git.PushOptions{
+		RefSpecs: []gitconfig.RefSpec{gitconfig.RefSpec(branch + ":" + branch)},
+		Auth:     auth,
+	}); err != nil && err != git.NoErrAlreadyUpToDate {
+		err = db.remoteError(err)
+		db.syncState.record(err)
 		return err
 	}
 
-	sshKey, err := db.loadSshkey()
+	db.syncState.record(nil)
+	return nil
+}
+
+// Pull fetches from the remote and merges the remote branch into the local branch.
+// Conflicting changes are kept as merge conflicts, see GetMergeConflicts.
+func (db *RecipeDatabase) Pull() error {
+	if db.config.Remote == "" {
+		return ErrNoRemote
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	auth, err := db.authMethod()
 	if err != nil {
 		return err
 	}
 
-	slog.Info("Pulling from remote", "remote", db.config.Remote)
+	slog.Info("Pulling from remote", "remote", redactedRemote(db.config.Remote))
 
-	err = worktree.Pull(&git.PullOptions{
-		Auth: sshKey,
+	err = db.repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       auth,
 	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+	// An empty remote has nothing to pull, until the first push
+	if err != nil && err != git.NoErrAlreadyUpToDate && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		err = db.remoteError(err)
+		db.syncState.record(err)
 		return err
 	}
 
+	err = db.mergeRemote()
+	db.syncState.record(err)
+	return err
+}
+
+// RebuildIndex throws away the in-memory index and reads the whole repository again
+func (db *RecipeDatabase) RebuildIndex() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	index, err := buildIndex(db.repo)
+	if err != nil {
+		return err
+	}
+	db.index = index
 	return nil
 }
 
-func (db *RecipeDatabase) loadSshkey() (*ssh.PublicKeys, error) {
-	if db.config.SshKeyPath == "" {
-		slog.Info("SSH key path not set, not auth")
-		return nil, nil
+// updateIndex moves the index to the new HEAD, rebuilding it if the incremental update fails
+func (db *RecipeDatabase) updateIndex(head plumbing.Hash) error {
+	err := db.index.update(db.repo, head)
+	if err == nil {
+		return nil
 	}
-	publicKey, err := ssh.NewPublicKeysFromFile("git", db.config.SshKeyPath, "")
 
+	slog.Warn("Failed to update recipe index, rebuilding", "err", err)
+	index, err := buildIndex(db.repo)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	db.index = index
+	return nil
+}
 
-	slog.Info("Loaded SSH key", "path", db.config.SshKeyPath)
-	return publicKey, nil
+// remoteError classifies an error from talking to the remote. The message is redacted, as
+// errors from the transport may include the remote URL or credentials.
+func (db *RecipeDatabase) remoteError(err error) error {
+	// Push reports a rejected update with an unwrapped error, so match the message too
+	if errors.Is(err, git.ErrNonFastForwardUpdate) || strings.Contains(err.Error(), "non-fast-forward update") {
+		return fmt.Errorf("%w: %s", ErrRemoteDiverged, db.redact(err))
+	}
+	return fmt.Errorf("%w: %s", ErrRemoteUnavailable, db.redact(err))
 }
 
 func (db *RecipeDatabase) getCommitEmail() string {
@@ -140,3 +307,23 @@ func (db *RecipeDatabase) getCommitEmail() string {
 
 	return db.config.CommitEmail
 }
+
+// commit commits the staged changes. Without parents, the parent is HEAD.
+func (db *RecipeDatabase) commit(worktree *git.Worktree, commitMessage, authorName string, parents ...plumbing.Hash) (plumbing.Hash, error) {
+	hash, err := worktree.Commit(commitMessage, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  authorName,
+			Email: db.getCommitEmail(),
+			When:  time.Now(),
+		},
+		Parents: parents,
+		// A merge commit may have the same tree as one of its parents
+		AllowEmptyCommits: len(parents) > 1,
+	})
+	if err != nil {
+		return hash, err
+	}
+	db.notifyChanged()
+
+	return hash, db.updateIndex(hash)
+}
@@ -0,0 +1,488 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jonasmh/recipetracker/pkg/drift"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// DirectoryStore keeps recipes and logs as plain JSON files, laid out like the git repository,
+// but without history or a remote. Commit messages and authors are ignored.
+type DirectoryStore struct {
+	fs   billy.Filesystem
+	mu   sync.RWMutex
+	lock *fileLock
+}
+
+func NewDirectoryStore(dir string) (*DirectoryStore, error) {
+	if dir == "" {
+		return nil, errors.New("storage directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Join(err, errors.New("failed to create storage directory"))
+	}
+
+	lock, err := acquireFileLock(filepath.Join(dir, "."+lockFileName))
+	if err != nil {
+		return nil, errors.Join(err, errors.New("failed to lock storage directory, is another recipetracker using it?"))
+	}
+
+	slog.Info("Opened storage directory", "path", dir)
+	return &DirectoryStore{
+		fs:   osfs.New(dir),
+		lock: lock,
+	}, nil
+}
+
+// Close releases the lock on the directory
+func (s *DirectoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lock.release()
+}
+
+// writeFile encodes v to the file, replacing it in one step so readers never see half a file
+func (s *DirectoryStore) writeFile(filePath string, v any) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return s.writeBytes(filePath, buf.Bytes())
+}
+
+func (s *DirectoryStore) writeBytes(filePath string, data []byte) error {
+	if err := s.fs.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := util.WriteFile(s.fs, tmp, data, 0644); err != nil {
+		return err
+	}
+	return s.fs.Rename(tmp, filePath)
+}
+
+func (s *DirectoryStore) exists(filePath string) bool {
+	_, err := s.fs.Stat(filePath)
+	return err == nil
+}
+
+func (s *DirectoryStore) GetRecipes(includeArchived bool) ([]models.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	recipes := make([]models.Recipe, 0)
+
+	dirInfo, err := s.fs.ReadDir(recipesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return recipes, nil
+		}
+		return nil, err
+	}
+
+	for _, dir := range dirInfo {
+		if !dir.IsDir() {
+			continue
+		}
+
+		recipe, err := s.getRecipe(dir.Name())
+		if err != nil {
+			if errors.Is(err, ErrRecipeNotFound) {
+				continue // Skip if the current.json file does not exist
+			}
+			return nil, err
+		}
+		if recipe.Archived && !includeArchived {
+			continue
+		}
+
+		recipes = append(recipes, recipe)
+	}
+
+	return recipes, nil
+}
+
+func (s *DirectoryStore) GetRecipe(id string) (models.Recipe, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getRecipe(id)
+}
+
+func (s *DirectoryStore) getRecipe(id string) (model models.Recipe, err error) {
+	file, err := s.fs.Open(recipesPath + id + "/current.json")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return model, fmt.Errorf("%w: %s", ErrRecipeNotFound, id)
+		}
+		return model, err
+	}
+	defer file.Close()
+
+	return decodeRecipe(file, id)
+}
+
+func (s *DirectoryStore) CreateRecipe(recipe models.Recipe, commitMessage, authorName string) (models.Recipe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if recipe.Id == "" {
+		recipe.Id = uniqueRecipeId(s.fs, recipe.Title)
+	} else if s.exists(recipesPath + recipe.Id) {
+		return recipe, fmt.Errorf("%w: %s", ErrRecipeExists, recipe.Id)
+	}
+
+	return recipe, s.writeFile(recipesPath+recipe.Id+"/current.json", recipe)
+}
+
+func (s *DirectoryStore) UpdateRecipe(recipe models.Recipe, commitMessage, authorName string) (models.Recipe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getRecipe(recipe.Id)
+	if err != nil {
+		return recipe, err
+	}
+	recipe = keepManagedFields(recipe, stored)
+	return recipe, s.writeFile(recipesPath+recipe.Id+"/current.json", recipe)
+}
+
+func (s *DirectoryStore) DeleteRecipe(id string, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.exists(recipesPath + id) {
+		return fmt.Errorf("%w: %s", ErrRecipeNotFound, id)
+	}
+	return util.RemoveAll(s.fs, recipesPath+id)
+}
+
+func (s *DirectoryStore) RenameRecipe(oldId, newId string, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recipe, err := s.getRecipe(oldId)
+	if err != nil {
+		return err
+	}
+	if s.exists(recipesPath + newId) {
+		return fmt.Errorf("%w: %s", ErrRecipeExists, newId)
+	}
+
+	if err := s.fs.Rename(recipesPath+oldId, recipesPath+newId); err != nil {
+		return err
+	}
+
+	recipe.Id = newId
+	recipe.PreviousIds = append(recipe.PreviousIds, oldId)
+	if err := s.writeFile(recipesPath+newId+"/current.json", recipe); err != nil {
+		return err
+	}
+
+	// The logs name the recipe they belong to, so they are moved over to the new id as well
+	dirInfo, err := s.fs.ReadDir(recipesPath + newId + "/logs/")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, logFile := range dirInfo {
+		if logFile.IsDir() || !strings.HasSuffix(logFile.Name(), ".json") {
+			continue
+		}
+		rlog, err := s.getRecipeLog(newId, strings.TrimSuffix(logFile.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		rlog.RecipeId = newId
+		if err := s.writeFile(recipeLogPath(newId, rlog.Id), rlog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirectoryStore) SetRecipeArchived(id string, archived bool, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recipe, err := s.getRecipe(id)
+	if err != nil {
+		return err
+	}
+
+	recipe.Archived = archived
+	return s.writeFile(recipesPath+id+"/current.json", recipe)
+}
+
+func (s *DirectoryStore) ForkRecipe(parentId, newId, title string, commitMessage, authorName string) (models.Recipe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parent, err := s.getRecipe(parentId)
+	if err != nil {
+		return parent, err
+	}
+
+	fork := newFork(parent, newId, title, "")
+	if fork.Id == "" {
+		fork.Id = uniqueRecipeId(s.fs, fork.Title)
+	} else if s.exists(recipesPath + fork.Id) {
+		return fork, fmt.Errorf("%w: %s", ErrRecipeExists, fork.Id)
+	}
+
+	return fork, s.writeFile(recipesPath+fork.Id+"/current.json", fork)
+}
+
+func (s *DirectoryStore) GetRecipeFamily(id string) (models.RecipeFamily, error) {
+	recipes, err := s.GetRecipes(true)
+	if err != nil {
+		return models.RecipeFamily{}, err
+	}
+	return recipeFamily(recipes, id)
+}
+
+func (s *DirectoryStore) GetRecipeLogs(recipeId string) ([]models.RecipeLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rlogs := make([]models.RecipeLog, 0)
+
+	dirInfo, err := s.fs.ReadDir(recipesPath + recipeId + "/logs/")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rlogs, nil
+		}
+		return nil, err
+	}
+	sort.Slice(dirInfo, func(i, j int) bool { return dirInfo[i].Name() < dirInfo[j].Name() })
+
+	for _, logFile := range dirInfo {
+		if logFile.IsDir() || !strings.HasSuffix(logFile.Name(), ".json") {
+			continue
+		}
+
+		rlog, err := s.getRecipeLog(recipeId, strings.TrimSuffix(logFile.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		rlogs = append(rlogs, *rlog)
+	}
+
+	return rlogs, nil
+}
+
+func (s *DirectoryStore) GetRecipeLog(recipeId string, logId string) (*models.RecipeLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getRecipeLog(recipeId, logId)
+}
+
+func (s *DirectoryStore) getRecipeLog(recipeId string, logId string) (*models.RecipeLog, error) {
+	file, err := s.fs.Open(recipeLogPath(recipeId, logId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s/%s", ErrLogNotFound, recipeId, logId)
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var rlog models.RecipeLog
+	if err := json.NewDecoder(file).Decode(&rlog); err != nil {
+		return nil, err
+	}
+	rlog.Id = logId
+
+	return &rlog, nil
+}
+
+func (s *DirectoryStore) AddRecipeLog(rlog models.RecipeLog, commitMessage, authorName string) (models.RecipeLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getRecipe(rlog.RecipeId); err != nil {
+		return rlog, err
+	}
+
+	if rlog.Id == "" {
+		rlog.Id = newLogId(s.fs, rlog.RecipeId)
+	}
+
+	filePath := recipeLogPath(rlog.RecipeId, rlog.Id)
+	if s.exists(filePath) {
+		return rlog, fmt.Errorf("%w: %s/%s", ErrLogExists, rlog.RecipeId, rlog.Id)
+	}
+
+	rlog.Commit = nil
+	rlog.CreatedCommit = nil
+	return rlog, s.writeFile(filePath, rlog)
+}
+
+// UpdateRecipeLog overwrites an existing log, keeping its PromotedCommit. Without history there
+// are no commits to compare, so lastCommit is ignored.
+func (s *DirectoryStore) UpdateRecipeLog(rlog models.RecipeLog, lastCommit string, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.getRecipeLog(rlog.RecipeId, rlog.Id)
+	if err != nil {
+		return err
+	}
+	rlog.PromotedCommit = stored.PromotedCommit
+	rlog.Commit = nil
+	rlog.CreatedCommit = nil
+	return s.writeFile(recipeLogPath(rlog.RecipeId, rlog.Id), rlog)
+}
+
+func (s *DirectoryStore) DeleteRecipeLog(recipeId string, logId string, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.fs.Remove(recipeLogPath(recipeId, logId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return util.RemoveAll(s.fs, logPhotosPath(recipeId, logId))
+}
+
+// PromoteRecipeLog applies the actual ingredients of the log to the recipe. Without commits,
+// the log records no promoted commit.
+func (s *DirectoryStore) PromoteRecipeLog(recipeId string, logId string, commitMessage, authorName string) (models.Recipe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recipe, err := s.getRecipe(recipeId)
+	if err != nil {
+		return recipe, err
+	}
+	rlog, err := s.getRecipeLog(recipeId, logId)
+	if err != nil {
+		return recipe, err
+	}
+	recipe, err = promoteIngredients(recipe, *rlog)
+	if err != nil {
+		return recipe, err
+	}
+
+	return recipe, s.writeFile(recipesPath+recipeId+"/current.json", recipe)
+}
+
+// GetRecipeDrift compares the actual ingredients of each log with the current recipe, as the
+// directory keeps no earlier versions
+func (s *DirectoryStore) GetRecipeDrift(recipeId string) (models.RecipeDrift, error) {
+	current, err := s.GetRecipe(recipeId)
+	if err != nil {
+		return models.RecipeDrift{}, err
+	}
+	rlogs, err := s.GetRecipeLogs(recipeId)
+	if err != nil {
+		return models.RecipeDrift{}, err
+	}
+
+	samples := make([]drift.Sample, len(rlogs))
+	for i, rlog := range rlogs {
+		samples[i] = drift.Sample{Log: rlog, Recipe: current}
+	}
+	return drift.Analyze(current, samples), nil
+}
+
+func (s *DirectoryStore) GetRecipeHistory(id string) ([]models.Commit, error) {
+	return nil, ErrNoHistory
+}
+
+func (s *DirectoryStore) GetRecipeVersion(id string, hash string) (models.Recipe, error) {
+	return models.Recipe{}, ErrNoHistory
+}
+
+func (s *DirectoryStore) RestoreRecipeVersion(id string, hash string, commitMessage, authorName string) (models.Recipe, error) {
+	return models.Recipe{}, ErrNoHistory
+}
+
+func (s *DirectoryStore) DiffRecipeVersions(id string, from, to string) (models.RecipeDiff, error) {
+	return models.RecipeDiff{}, ErrNoHistory
+}
+
+func (s *DirectoryStore) GetExperiments(recipeId string) ([]models.Experiment, error) {
+	return nil, ErrNoHistory
+}
+
+func (s *DirectoryStore) GetExperiment(recipeId, id string) (models.Experiment, error) {
+	return models.Experiment{}, ErrNoHistory
+}
+
+func (s *DirectoryStore) CreateExperiment(recipeId, id string) (models.Experiment, error) {
+	return models.Experiment{}, ErrNoHistory
+}
+
+func (s *DirectoryStore) GetExperimentRecipe(recipeId, id string) (models.Recipe, error) {
+	return models.Recipe{}, ErrNoHistory
+}
+
+func (s *DirectoryStore) UpdateExperimentRecipe(id string, recipe models.Recipe, commitMessage, authorName string) error {
+	return ErrNoHistory
+}
+
+func (s *DirectoryStore) GetExperimentLogs(recipeId, id string) ([]models.RecipeLog, error) {
+	return nil, ErrNoHistory
+}
+
+func (s *DirectoryStore) AddExperimentLog(id string, rlog models.RecipeLog, commitMessage, authorName string) (models.RecipeLog, error) {
+	return rlog, ErrNoHistory
+}
+
+func (s *DirectoryStore) MergeExperiment(recipeId, id string, commitMessage, authorName string) error {
+	return ErrNoHistory
+}
+
+func (s *DirectoryStore) DiscardExperiment(recipeId, id string) error {
+	return ErrNoHistory
+}
+
+func (s *DirectoryStore) Push() error {
+	return ErrNoRemote
+}
+
+func (s *DirectoryStore) Pull() error {
+	return ErrNoRemote
+}
+
+// Status reports that there is no remote
+func (s *DirectoryStore) Status() (models.SyncStatus, error) {
+	return models.SyncStatus{}, nil
+}
+
+// GetMergeConflicts returns no conflicts, as nothing is ever merged
+func (s *DirectoryStore) GetMergeConflicts() ([]models.MergeConflict, error) {
+	return make([]models.MergeConflict, 0), nil
+}
+
+func (s *DirectoryStore) GetMergeConflict(id string) (models.MergeConflict, error) {
+	return models.MergeConflict{}, fmt.Errorf("%w: %s", ErrMergeConflictNotFound, id)
+}
+
+func (s *DirectoryStore) ResolveMergeConflict(id string, resolved json.RawMessage, commitMessage, authorName string) error {
+	return fmt.Errorf("%w: %s", ErrMergeConflictNotFound, id)
+}
+
+// RebuildIndex does nothing, as every read goes to the files
+func (s *DirectoryStore) RebuildIndex() error {
+	return nil
+}
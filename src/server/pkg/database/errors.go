@@ -0,0 +1,54 @@
+package database
+
+import "errors"
+
+// Kinds of errors returned by the database. Every error below wraps one of these,
+// so callers can check errors.Is(err, ErrNotFound) without knowing the specific error.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflict")
+	ErrInvalid           = errors.New("invalid")
+	ErrRemoteUnavailable = errors.New("remote unavailable")
+	ErrUnsupported       = errors.New("unsupported")
+	// ErrPreconditionFailed is returned when what the caller last read is no longer current
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+var (
+	ErrRecipeNotFound        = newError(ErrNotFound, "recipe not found")
+	ErrRecipeExists          = newError(ErrConflict, "recipe already exists")
+	ErrVersionNotFound       = newError(ErrNotFound, "recipe version not found")
+	ErrInvalidVersion        = newError(ErrInvalid, "invalid recipe version")
+	ErrLogNotFound           = newError(ErrNotFound, "recipe log not found")
+	ErrLogExists             = newError(ErrConflict, "recipe log already exists")
+	ErrLogModified           = newError(ErrPreconditionFailed, "recipe log was modified since it was read")
+	ErrNoRemote              = newError(ErrInvalid, "no remote configured")
+	ErrRemoteDiverged        = newError(ErrConflict, "remote has diverged")
+	ErrNoHistory             = newError(ErrUnsupported, "storage backend keeps no history")
+	ErrMergeConflictNotFound = newError(ErrNotFound, "merge conflict not found")
+	ErrNothingToPromote      = newError(ErrInvalid, "recipe log has no actual ingredients")
+	ErrPhotoNotFound         = newError(ErrNotFound, "photo not found")
+	ErrInvalidPhoto          = newError(ErrInvalid, "invalid photo")
+	ErrAttachmentNotFound    = newError(ErrNotFound, "attachment not found")
+	ErrAttachmentMissing     = newError(ErrNotFound, "attachment is not in the attachment store")
+	ErrInvalidAttachment     = newError(ErrInvalid, "invalid attachment")
+	ErrExperimentNotFound    = newError(ErrNotFound, "experiment not found")
+	ErrExperimentExists      = newError(ErrConflict, "experiment already exists")
+)
+
+type dbError struct {
+	kind    error
+	message string
+}
+
+func newError(kind error, message string) error {
+	return &dbError{kind: kind, message: message}
+}
+
+func (e *dbError) Error() string {
+	return e.message
+}
+
+func (e *dbError) Unwrap() error {
+	return e.kind
+}
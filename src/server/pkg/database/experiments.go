@@ -0,0 +1,469 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// experimentsRefPrefix is where experiment branches are kept, as experiments/<recipeId>/<id>.
+// Experiments are local, and are not pushed to the remote.
+const experimentsRefPrefix = "refs/heads/experiments/"
+
+func experimentBranch(recipeId, id string) plumbing.ReferenceName {
+	return plumbing.ReferenceName(experimentsRefPrefix + recipeId + "/" + id)
+}
+
+// GetExperiments lists the open experiments of a recipe, sorted by id
+func (db *RecipeDatabase) GetExperiments(recipeId string) ([]models.Experiment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	refs, err := db.repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	prefix := string(experimentBranch(recipeId, ""))
+	var branches []*plumbing.Reference
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), prefix) {
+			branches = append(branches, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name() < branches[j].Name() })
+
+	experiments := make([]models.Experiment, 0, len(branches))
+	for _, ref := range branches {
+		experiment, err := db.getExperiment(recipeId, ref)
+		if err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, experiment)
+	}
+	return experiments, nil
+}
+
+func (db *RecipeDatabase) GetExperiment(recipeId, id string) (models.Experiment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ref, err := db.experimentRef(recipeId, id)
+	if err != nil {
+		return models.Experiment{}, err
+	}
+	return db.getExperiment(recipeId, ref)
+}
+
+func (db *RecipeDatabase) experimentRef(recipeId, id string) (*plumbing.Reference, error) {
+	ref, err := db.repo.Reference(experimentBranch(recipeId, id), false)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, fmt.Errorf("%w: %s/%s", ErrExperimentNotFound, recipeId, id)
+		}
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (db *RecipeDatabase) getExperiment(recipeId string, ref *plumbing.Reference) (models.Experiment, error) {
+	experiment := models.Experiment{
+		Id:       strings.TrimPrefix(ref.Name().String(), string(experimentBranch(recipeId, ""))),
+		RecipeId: recipeId,
+		Branch:   ref.Name().Short(),
+		Commits:  make([]models.Commit, 0),
+	}
+
+	commit, err := db.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return experiment, err
+	}
+	head, err := db.repo.Head()
+	if err != nil {
+		return experiment, err
+	}
+	headCommit, err := db.repo.CommitObject(head.Hash())
+	if err != nil {
+		return experiment, err
+	}
+	bases, err := commit.MergeBase(headCommit)
+	if err != nil {
+		return experiment, err
+	}
+	if len(bases) > 0 {
+		experiment.BaseCommit = bases[0].Hash.String()
+	}
+
+	logIter, err := db.repo.Log(&git.LogOptions{From: commit.Hash})
+	if err != nil {
+		return experiment, err
+	}
+	defer logIter.Close()
+	err = logIter.ForEach(func(c *object.Commit) error {
+		if c.Hash.String() == experiment.BaseCommit {
+			return storer.ErrStop
+		}
+		experiment.Commits = append(experiment.Commits, convertToCommitModel(c))
+		return nil
+	})
+	return experiment, err
+}
+
+// CreateExperiment starts an experiment on a recipe, branching off the current version
+func (db *RecipeDatabase) CreateExperiment(recipeId, id string) (models.Experiment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.getRecipe(recipeId); err != nil {
+		return models.Experiment{}, err
+	}
+	if _, err := db.experimentRef(recipeId, id); err == nil {
+		return models.Experiment{}, fmt.Errorf("%w: %s/%s", ErrExperimentExists, recipeId, id)
+	}
+
+	head, err := db.repo.Head()
+	if err != nil {
+		return models.Experiment{}, err
+	}
+	ref := plumbing.NewHashReference(experimentBranch(recipeId, id), head.Hash())
+	if err := db.repo.Storer.SetReference(ref); err != nil {
+		return models.Experiment{}, err
+	}
+
+	slog.Info("Created experiment", "recipeId", recipeId, "experiment", id)
+	return db.getExperiment(recipeId, ref)
+}
+
+// GetExperimentRecipe reads the recipe as it is in the experiment
+func (db *RecipeDatabase) GetExperimentRecipe(recipeId, id string) (models.Recipe, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getExperimentRecipe(recipeId, id)
+}
+
+func (db *RecipeDatabase) getExperimentRecipe(recipeId, id string) (models.Recipe, error) {
+	_, tree, err := db.experimentTree(recipeId, id)
+	if err != nil {
+		return models.Recipe{}, err
+	}
+	data, err := readTreeFile(tree, recipesPath+recipeId+"/current.json")
+	if err != nil {
+		return models.Recipe{}, err
+	}
+	if data == nil {
+		return models.Recipe{}, fmt.Errorf("%w: %s in experiment %s", ErrRecipeNotFound, recipeId, id)
+	}
+	return decodeRecipe(bytes.NewReader(data), recipeId)
+}
+
+// UpdateExperimentRecipe commits a change to the recipe to the experiment, leaving the recipe
+// on the main branch as it is. Like UpdateRecipe, the fields managed by the store are kept.
+func (db *RecipeDatabase) UpdateExperimentRecipe(id string, recipe models.Recipe, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ref, err := db.experimentRef(recipe.Id, id)
+	if err != nil {
+		return err
+	}
+	stored, err := db.getExperimentRecipe(recipe.Id, id)
+	if err != nil {
+		return err
+	}
+	data, err := encodeJSON(keepManagedFields(recipe, stored))
+	if err != nil {
+		return err
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Update recipe %s in experiment %s", recipe.Id, id)
+	}
+	_, err = db.commitToBranch(ref, map[string][]byte{recipesPath + recipe.Id + "/current.json": data}, commitMessage, authorName)
+	return err
+}
+
+// GetExperimentLogs returns the logs of the recipe as they are in the experiment
+func (db *RecipeDatabase) GetExperimentLogs(recipeId, id string) ([]models.RecipeLog, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, tree, err := db.experimentTree(recipeId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rlogs := make([]models.RecipeLog, 0)
+	logsTree, err := tree.Tree(recipesPath + recipeId + "/logs")
+	if err != nil {
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return rlogs, nil
+		}
+		return nil, err
+	}
+
+	// Tree entries are sorted by name, and so by log id
+	for _, entry := range logsTree.Entries {
+		logId, ok := strings.CutSuffix(entry.Name, ".json")
+		if !ok || !entry.Mode.IsFile() {
+			continue
+		}
+		data, err := readTreeFile(logsTree, entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		var rlog models.RecipeLog
+		if err := json.Unmarshal(data, &rlog); err != nil {
+			return nil, err
+		}
+		rlog.Id = logId
+		rlogs = append(rlogs, rlog)
+	}
+	return rlogs, nil
+}
+
+// AddExperimentLog stores a new log in the experiment. If the log has no id, a time-sortable id is generated.
+func (db *RecipeDatabase) AddExperimentLog(id string, rlog models.RecipeLog, commitMessage, authorName string) (models.RecipeLog, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ref, tree, err := db.experimentTree(rlog.RecipeId, id)
+	if err != nil {
+		return rlog, err
+	}
+
+	exists := func(logId string) bool {
+		_, err := tree.File(recipeLogPath(rlog.RecipeId, logId))
+		return err == nil
+	}
+	if rlog.Id == "" {
+		rlog.Id = newTimeId(exists)
+	} else if exists(rlog.Id) {
+		return rlog, fmt.Errorf("%w: %s/%s", ErrLogExists, rlog.RecipeId, rlog.Id)
+	}
+
+	rlog.Commit = nil
+	rlog.CreatedCommit = nil
+	data, err := encodeJSON(rlog)
+	if err != nil {
+		return rlog, err
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Add log %s in experiment %s", rlog.Id, id)
+	}
+	_, err = db.commitToBranch(ref, map[string][]byte{recipeLogPath(rlog.RecipeId, rlog.Id): data}, commitMessage, authorName)
+	return rlog, err
+}
+
+// MergeExperiment merges the changes made in the experiment into the recipe, and removes the
+// experiment. Changes that conflict with changes made to the recipe since are kept as merge
+// conflicts, see GetMergeConflicts.
+func (db *RecipeDatabase) MergeExperiment(recipeId, id string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ref, err := db.experimentRef(recipeId, id)
+	if err != nil {
+		return err
+	}
+	theirs, err := db.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return err
+	}
+	head, err := db.repo.Head()
+	if err != nil {
+		return err
+	}
+	ours, err := db.repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	// An experiment without commits of its own has nothing to merge
+	if merged, err := theirs.IsAncestor(ours); err != nil {
+		return err
+	} else if !merged {
+		if commitMessage == "" {
+			commitMessage = fmt.Sprintf("Merge experiment %s into recipe %s", id, recipeId)
+		}
+		if err := db.merge(ours, theirs, commitMessage, authorName); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Merged experiment", "recipeId", recipeId, "experiment", id)
+	return db.repo.Storer.RemoveReference(ref.Name())
+}
+
+// DiscardExperiment removes the experiment, without changing the recipe
+func (db *RecipeDatabase) DiscardExperiment(recipeId, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ref, err := db.experimentRef(recipeId, id)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Discarded experiment", "recipeId", recipeId, "experiment", id, "commit", ref.Hash().String())
+	return db.repo.Storer.RemoveReference(ref.Name())
+}
+
+func (db *RecipeDatabase) experimentTree(recipeId, id string) (*plumbing.Reference, *object.Tree, error) {
+	ref, err := db.experimentRef(recipeId, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	commit, err := db.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, tree, nil
+}
+
+// commitToBranch commits changes to a branch that is not checked out, without touching the
+// worktree or the index. A nil file is deleted.
+func (db *RecipeDatabase) commitToBranch(ref *plumbing.Reference, files map[string][]byte, commitMessage, authorName string) (plumbing.Hash, error) {
+	parent, err := db.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	tree, err := parent.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	treeHash, _, err := writeTree(db.repo.Storer, tree, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	signature := object.Signature{Name: authorName, Email: db.getCommitEmail(), When: time.Now()}
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      commitMessage,
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	}
+	obj := db.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	hash, err := db.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := db.repo.Storer.CheckAndSetReference(plumbing.NewHashReference(ref.Name(), hash), ref); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return hash, nil
+}
+
+// writeTree stores a copy of tree with the files changed, and returns its hash. Directories left
+// empty are removed, and empty is true if nothing is left in the tree.
+func writeTree(s storer.EncodedObjectStorer, tree *object.Tree, files map[string][]byte) (hash plumbing.Hash, empty bool, err error) {
+	entries := make(map[string]object.TreeEntry)
+	if tree != nil {
+		for _, entry := range tree.Entries {
+			entries[entry.Name] = entry
+		}
+	}
+
+	subdirs := make(map[string]map[string][]byte)
+	for filePath, data := range files {
+		if name, rest, isDir := strings.Cut(filePath, "/"); isDir {
+			if subdirs[name] == nil {
+				subdirs[name] = make(map[string][]byte)
+			}
+			subdirs[name][rest] = data
+			continue
+		}
+		if data == nil {
+			delete(entries, filePath)
+			continue
+		}
+		blobHash, err := writeBlob(s, data)
+		if err != nil {
+			return hash, false, err
+		}
+		entries[filePath] = object.TreeEntry{Name: filePath, Mode: filemode.Regular, Hash: blobHash}
+	}
+
+	for name, subFiles := range subdirs {
+		var subtree *object.Tree
+		if entry, ok := entries[name]; ok && entry.Mode == filemode.Dir {
+			if subtree, err = object.GetTree(s, entry.Hash); err != nil {
+				return hash, false, err
+			}
+		}
+		subHash, subEmpty, err := writeTree(s, subtree, subFiles)
+		if err != nil {
+			return hash, false, err
+		}
+		if subEmpty {
+			delete(entries, name)
+		} else {
+			entries[name] = object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: subHash}
+		}
+	}
+
+	sorted := make([]object.TreeEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	// Git sorts directories as if their names end with a slash
+	sortKey := func(entry object.TreeEntry) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sortKey(sorted[i]) < sortKey(sorted[j]) })
+
+	obj := s.NewEncodedObject()
+	if err := (&object.Tree{Entries: sorted}).Encode(obj); err != nil {
+		return hash, false, err
+	}
+	hash, err = s.SetEncodedObject(obj)
+	return hash, len(sorted) == 0, err
+}
+
+func writeBlob(s storer.EncodedObjectStorer, data []byte) (plumbing.Hash, error) {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// newFork copies the parent into a new recipe. The fork starts without logs, rename trail or archiving.
+func newFork(parent models.Recipe, newId, title, commit string) models.Recipe {
+	fork := parent
+	fork.Id = newId
+	if title != "" {
+		fork.Title = title
+	}
+	fork.Archived = false
+	fork.PreviousIds = nil
+	fork.ForkedFrom = &models.RecipeParent{RecipeId: parent.Id, Commit: commit}
+	return fork
+}
+
+// ForkRecipe creates a new recipe from the current version of another. If newId is empty, an id
+// is generated from the title, which defaults to the title of the parent.
+func (db *RecipeDatabase) ForkRecipe(parentId, newId, title string, commitMessage, authorName string) (models.Recipe, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	parent, err := db.getRecipe(parentId)
+	if err != nil {
+		return parent, err
+	}
+
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return parent, err
+	}
+
+	var commit string
+	head, err := db.repo.Head()
+	if err == nil {
+		commit = head.Hash().String()
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return parent, err
+	}
+
+	fork := newFork(parent, newId, title, commit)
+	if fork.Id == "" {
+		fork.Id = uniqueRecipeId(worktree.Filesystem, fork.Title)
+	} else if _, err := worktree.Filesystem.Stat(recipesPath + fork.Id); err == nil {
+		return fork, fmt.Errorf("%w: %s", ErrRecipeExists, fork.Id)
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Fork recipe %s as %s", parentId, fork.Id)
+	}
+	if err := db.addOrUpdateRecipe(fork, commitMessage, authorName); err != nil {
+		return fork, err
+	}
+
+	return fork, nil
+}
+
+// GetRecipeFamily returns the family tree the recipe belongs to, from its oldest ancestor
+func (db *RecipeDatabase) GetRecipeFamily(id string) (models.RecipeFamily, error) {
+	recipes, err := db.GetRecipes(true)
+	if err != nil {
+		return models.RecipeFamily{}, err
+	}
+	return recipeFamily(recipes, id)
+}
+
+// recipeFamily builds the family tree of the recipe with the given id. Parents are looked up by
+// their previous ids too, so forks of a renamed recipe stay in the tree. When the oldest ancestor
+// was forked from a recipe that has since been deleted, its ForkedFrom still names it.
+func recipeFamily(recipes []models.Recipe, id string) (models.RecipeFamily, error) {
+	byId := make(map[string]models.Recipe, len(recipes))
+	for _, recipe := range recipes {
+		for _, previousId := range recipe.PreviousIds {
+			byId[previousId] = recipe
+		}
+	}
+	// Current ids win over previous ids, in case an id was reused after a rename
+	for _, recipe := range recipes {
+		byId[recipe.Id] = recipe
+	}
+
+	root, ok := byId[id]
+	if !ok || root.Id != id {
+		return models.RecipeFamily{}, fmt.Errorf("%w: %s", ErrRecipeNotFound, id)
+	}
+
+	visited := map[string]bool{root.Id: true}
+	for root.ForkedFrom != nil {
+		parent, ok := byId[root.ForkedFrom.RecipeId]
+		if !ok || visited[parent.Id] {
+			break
+		}
+		visited[parent.Id] = true
+		root = parent
+	}
+
+	forks := make(map[string][]models.Recipe)
+	for _, recipe := range recipes {
+		if recipe.ForkedFrom == nil {
+			continue
+		}
+		if parent, ok := byId[recipe.ForkedFrom.RecipeId]; ok {
+			forks[parent.Id] = append(forks[parent.Id], recipe)
+		}
+	}
+
+	var build func(recipe models.Recipe, seen map[string]bool) models.RecipeFamily
+	build = func(recipe models.Recipe, seen map[string]bool) models.RecipeFamily {
+		seen[recipe.Id] = true
+		node := models.RecipeFamily{
+			Id:         recipe.Id,
+			Title:      recipe.Title,
+			Archived:   recipe.Archived,
+			ForkedFrom: recipe.ForkedFrom,
+			Forks:      make([]models.RecipeFamily, 0),
+		}
+		children := forks[recipe.Id]
+		sort.Slice(children, func(i, j int) bool { return children[i].Id < children[j].Id })
+		for _, child := range children {
+			if !seen[child.Id] {
+				node.Forks = append(node.Forks, build(child, seen))
+			}
+		}
+		return node
+	}
+
+	return build(root, make(map[string]bool)), nil
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/gosimple/unidecode"
+)
+
+const maxSlugLength = 64
+
+// transliterations maps letters to their common spelling, taking precedence over unidecode,
+// which spells e.g. the Danish "ø" as "o" rather than "oe"
+var transliterations = map[rune]string{
+	'æ': "ae", 'ø': "oe", 'å': "aa", 'ß': "ss", 'œ': "oe", 'þ': "th", 'ð': "d", 'ł': "l", 'đ': "d",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'ç': "c", 'ć': "c", 'č': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ō': "o", 'ő': "o",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ť': "t", 'ţ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// slugify turns a title into a lowercase, dash separated id, e.g. "Rødgrød med fløde" becomes
+// "roedgroed-med-floede". Letters of other scripts are transliterated, so "Борщ" becomes "borshch".
+func slugify(title string) string {
+	var ascii strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r < unicode.MaxASCII:
+			ascii.WriteRune(r)
+		case transliterations[r] != "":
+			ascii.WriteString(transliterations[r])
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			ascii.WriteString(unidecode.Unidecode(string(r)))
+		default:
+			ascii.WriteRune(' ')
+		}
+	}
+
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(ascii.String()) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	slug := strings.Trim(b.String(), "-")
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	if slug == "" {
+		return "recipe"
+	}
+	return slug
+}
+
+// uniqueRecipeId returns a slug of the title that is not already used by a recipe,
+// adding a numeric suffix on collision
+func uniqueRecipeId(fs billy.Filesystem, title string) string {
+	slug := slugify(title)
+	id := slug
+	for i := 2; ; i++ {
+		if _, err := fs.Stat(recipesPath + id); err != nil {
+			return id
+		}
+		id = slug + "-" + strconv.Itoa(i)
+	}
+}
+
+// newLogId returns a new id for a recipe log. Ids sort by the time they were created.
+func newLogId(fs billy.Filesystem, recipeId string) string {
+	return newTimeId(func(id string) bool {
+		_, err := fs.Stat(recipesPath + recipeId + "/logs/" + id + ".json")
+		return err == nil
+	})
+}
+
+// newConflictId returns a new id for a merge conflict in fs, sorting by the time of the merge
+func newConflictId(fs billy.Filesystem) string {
+	return newTimeId(func(id string) bool {
+		_, err := fs.Stat(conflictPath(id))
+		return err == nil
+	})
+}
+
+// newTimeId returns an id made of the current time and a random suffix, that is not already taken
+func newTimeId(taken func(id string) bool) string {
+	for {
+		suffix := make([]byte, 2)
+		_, _ = rand.Read(suffix)
+		id := time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+		if !taken(id) {
+			return id
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/search"
+)
+
+// recipeIndex keeps the recipes and logs at HEAD in memory, together with the commits that
+// last changed each recipe and created and last changed each log, so reads don't have to go through git.
+// Files are kept as raw JSON, so every read decodes a fresh copy callers are free to modify.
+type recipeIndex struct {
+	head    plumbing.Hash
+	recipes map[string][]byte
+	// modified is the commit that last changed each recipe
+	modified map[string]*models.Commit
+	logs     map[string]map[string]*indexedLog
+	// search indexes the text of the recipes, and is updated with them
+	search *search.Index
+}
+
+type indexedLog struct {
+	data    []byte
+	created *models.Commit
+	latest  *models.Commit
+}
+
+func newRecipeIndex() *recipeIndex {
+	return &recipeIndex{
+		recipes:  make(map[string][]byte),
+		modified: make(map[string]*models.Commit),
+		logs:     make(map[string]map[string]*indexedLog),
+		search:   search.NewIndex(),
+	}
+}
+
+// parseRecipePath splits paths like recipes/<id>/current.json and recipes/<id>/logs/<logId>.json.
+// logId is empty for current.json, and ok is false for any other file.
+func parseRecipePath(filePath string) (recipeId, logId string, ok bool) {
+	rest, found := strings.CutPrefix(filePath, recipesPath)
+	if !found {
+		return "", "", false
+	}
+	parts := strings.Split(rest, "/")
+	switch {
+	case len(parts) == 2 && parts[1] == "current.json":
+		return parts[0], "", true
+	case len(parts) == 3 && parts[1] == "logs" && strings.HasSuffix(parts[2], ".json"):
+		return parts[0], strings.TrimSuffix(parts[2], ".json"), true
+	}
+	return "", "", false
+}
+
+// buildIndex reads every recipe and log at HEAD, and walks the full history once to find
+// the commits of each log. The index is not persisted, so opening a repository reads the changes
+// of every commit, and takes time in proportion to the length of its history. BenchmarkIndex
+// measures it.
+func buildIndex(repo *git.Repository) (*recipeIndex, error) {
+	start := time.Now()
+	idx := newRecipeIndex()
+
+	head, err := repo.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return idx, nil // Empty repository
+		}
+		return nil, err
+	}
+
+	if err := idx.apply(repo, head.Hash(), nil); err != nil {
+		return nil, err
+	}
+
+	slog.Info("Built recipe index", "recipes", len(idx.recipes), "head", idx.head.String(), "duration", time.Since(start))
+	return idx, nil
+}
+
+// update brings the index from its current head to newHead. When newHead is a linear descendant
+// of the current head only the new commits are read, otherwise the index is rebuilt.
+func (idx *recipeIndex) update(repo *git.Repository, newHead plumbing.Hash) error {
+	if newHead == idx.head {
+		return nil
+	}
+
+	var commits []*object.Commit
+	linear := false
+	if !idx.head.IsZero() {
+		commit, err := repo.CommitObject(newHead)
+		if err != nil {
+			return err
+		}
+		for commit.NumParents() <= 1 {
+			commits = append(commits, commit)
+			if commit.NumParents() == 0 {
+				break
+			}
+			if commit.ParentHashes[0] == idx.head {
+				linear = true
+				break
+			}
+			if commit, err = commit.Parent(0); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !linear {
+		rebuilt, err := buildIndex(repo)
+		if err != nil {
+			return err
+		}
+		*idx = *rebuilt
+		return nil
+	}
+
+	return idx.apply(repo, newHead, commits)
+}
+
+// apply walks the commits from newHead (newest first) and updates the index with the files
+// they touched. With a nil commits list, the whole history is walked and every file is read.
+func (idx *recipeIndex) apply(repo *git.Repository, newHead plumbing.Hash, commits []*object.Commit) error {
+	full := commits == nil
+	if full {
+		logIter, err := repo.Log(&git.LogOptions{From: newHead})
+		if err != nil {
+			return err
+		}
+		defer logIter.Close()
+		for {
+			commit, err := logIter.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			commits = append(commits, commit)
+		}
+	}
+
+	headCommit, err := repo.CommitObject(newHead)
+	if err != nil {
+		return err
+	}
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return err
+	}
+
+	// Walking newest first, the first commit seen for a file is the latest one,
+	// and the first insert seen is the one that created the current file
+	latestSet := make(map[string]bool)
+	createdSet := make(map[string]bool)
+	touched := make(map[string]bool)
+	commitModels := make(map[string]*models.Commit)
+	// movedTo maps the paths logs were moved from by recipe renames to where they are now, so the
+	// insert that created a log at its old path counts as its creation
+	movedTo := make(map[string]string)
+	owner := func(filePath string) string {
+		for {
+			to, ok := movedTo[filePath]
+			if !ok {
+				return filePath
+			}
+			filePath = to
+		}
+	}
+
+	for _, commit := range commits {
+		changes, err := commitChanges(commit)
+		if err != nil {
+			return err
+		}
+		moves, err := movedLogs(commit, changes)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			filePath, action := change.path, change.action
+			recipeId, logId, ok := parseRecipePath(filePath)
+			if !ok {
+				continue
+			}
+			touched[filePath] = true
+			if action == merkletrie.Delete {
+				continue
+			}
+
+			commitModel, ok := commitModels[commit.Hash.String()]
+			if !ok {
+				m := convertToCommitModel(commit)
+				commitModel = &m
+				commitModels[commit.Hash.String()] = commitModel
+			}
+
+			if logId == "" {
+				if !latestSet[filePath] {
+					idx.modified[recipeId] = commitModel
+					latestSet[filePath] = true
+				}
+				continue
+			}
+			log := idx.log(recipeId, logId)
+			if !latestSet[filePath] {
+				log.latest = commitModel
+				latestSet[filePath] = true
+			}
+			if action != merkletrie.Insert {
+				continue
+			}
+			createdPath := owner(filePath)
+			if createdSet[createdPath] {
+				continue
+			}
+			createdId, createdLogId, _ := parseRecipePath(createdPath)
+			created := idx.log(createdId, createdLogId)
+			from, moved := moves[filePath]
+			if !moved {
+				created.created = commitModel
+				createdSet[createdPath] = true
+				continue
+			}
+			fromId, _, _ := parseRecipePath(from)
+			if old, ok := idx.logs[fromId][logId]; ok && old.created != nil {
+				// Already indexed where it was moved from
+				created.created = old.created
+				createdSet[createdPath] = true
+				continue
+			}
+			// Until the insert at the old path turns up further back in history
+			created.created = commitModel
+			movedTo[from] = createdPath
+		}
+	}
+
+	if full {
+		// Read everything at HEAD, so files are indexed even if their history is odd
+		err := headTree.Files().ForEach(func(file *object.File) error {
+			touched[file.Name] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	recipeIds := make(map[string]bool)
+	for filePath := range touched {
+		if err := idx.load(headTree, filePath); err != nil {
+			return err
+		}
+		recipeId, _, _ := parseRecipePath(filePath)
+		recipeIds[recipeId] = true
+	}
+	for recipeId := range recipeIds {
+		idx.reindex(recipeId)
+	}
+
+	idx.head = newHead
+	return nil
+}
+
+// movedLogs finds the logs the commit moved from a recipe to the recipe it renamed it to, mapping
+// the new path of each log to its old one. A rename shows up as the log being deleted under an id
+// the recipe lists in its PreviousIds, and inserted under the recipe's id.
+func movedLogs(commit *object.Commit, changes []fileChange) (map[string]string, error) {
+	deleted := make(map[string][]string)
+	for _, change := range changes {
+		if change.action != merkletrie.Delete {
+			continue
+		}
+		if recipeId, logId, ok := parseRecipePath(change.path); ok && logId != "" {
+			deleted[logId] = append(deleted[logId], recipeId)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	previousIds := make(map[string][]string)
+	moves := make(map[string]string)
+	for _, change := range changes {
+		if change.action != merkletrie.Insert {
+			continue
+		}
+		recipeId, logId, ok := parseRecipePath(change.path)
+		if !ok || logId == "" || len(deleted[logId]) == 0 {
+			continue
+		}
+		previous, ok := previousIds[recipeId]
+		if !ok {
+			previous = recipePreviousIds(tree, recipeId)
+			previousIds[recipeId] = previous
+		}
+		for _, fromId := range deleted[logId] {
+			if slices.Contains(previous, fromId) {
+				moves[change.path] = recipeLogPath(fromId, logId)
+				break
+			}
+		}
+	}
+	return moves, nil
+}
+
+// recipePreviousIds reads the ids the recipe had before being renamed from the tree, or none if
+// it can't be read
+func recipePreviousIds(tree *object.Tree, recipeId string) []string {
+	file, err := tree.File(recipesPath + recipeId + "/current.json")
+	if err != nil {
+		return nil
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil
+	}
+	recipe, err := decodeRecipe(strings.NewReader(contents), recipeId)
+	if err != nil {
+		return nil
+	}
+	return recipe.PreviousIds
+}
+
+// fileChange is a file inserted, modified or deleted by a commit
+type fileChange struct {
+	path   string
+	action merkletrie.Action
+}
+
+// commitChanges returns the files changed by the commit compared to its first parent, sorted
+func commitChanges(commit *object.Commit) ([]fileChange, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := diffTrees(parentTree, tree, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, nil
+}
+
+// diffTrees appends the files that differ between the trees, either of which may be nil, to
+// changes. Subtrees with the same hash are skipped without being read, unlike object.DiffTree,
+// which reads every subtree and makes indexing a commit slow in proportion to the recipe count.
+func diffTrees(from, to *object.Tree, prefix string, changes []fileChange) ([]fileChange, error) {
+	fromEntries := make(map[string]object.TreeEntry)
+	if from != nil {
+		for _, entry := range from.Entries {
+			fromEntries[entry.Name] = entry
+		}
+	}
+	var err error
+	if to != nil {
+		for _, entry := range to.Entries {
+			old, ok := fromEntries[entry.Name]
+			delete(fromEntries, entry.Name)
+			if ok && old.Hash == entry.Hash && old.Mode == entry.Mode {
+				continue
+			}
+			var fromEntry *object.TreeEntry
+			if ok {
+				fromEntry = &old
+			}
+			if changes, err = diffEntries(from, to, fromEntry, &entry, prefix, changes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, entry := range fromEntries {
+		if changes, err = diffEntries(from, to, &entry, nil, prefix, changes); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// diffEntries appends the changes between an entry of from and an entry of to with the same name,
+// either of which may be nil
+func diffEntries(from, to *object.Tree, fromEntry, toEntry *object.TreeEntry, prefix string, changes []fileChange) ([]fileChange, error) {
+	var fromTree, toTree *object.Tree
+	var err error
+	if fromEntry != nil && fromEntry.Mode == filemode.Dir {
+		if fromTree, err = from.Tree(fromEntry.Name); err != nil {
+			return nil, err
+		}
+	}
+	if toEntry != nil && toEntry.Mode == filemode.Dir {
+		if toTree, err = to.Tree(toEntry.Name); err != nil {
+			return nil, err
+		}
+	}
+	if fromTree != nil || toTree != nil {
+		name := fromEntry
+		if name == nil {
+			name = toEntry
+		}
+		if changes, err = diffTrees(fromTree, toTree, prefix+name.Name+"/", changes); err != nil {
+			return nil, err
+		}
+	}
+
+	fromFile := fromEntry != nil && fromTree == nil
+	toFile := toEntry != nil && toTree == nil
+	switch {
+	case fromFile && toFile:
+		changes = append(changes, fileChange{prefix + toEntry.Name, merkletrie.Modify})
+	case fromFile:
+		changes = append(changes, fileChange{prefix + fromEntry.Name, merkletrie.Delete})
+	case toFile:
+		changes = append(changes, fileChange{prefix + toEntry.Name, merkletrie.Insert})
+	}
+	return changes, nil
+}
+
+// load reads the file from the tree into the index, or removes it if it no longer exists
+func (idx *recipeIndex) load(tree *object.Tree, filePath string) error {
+	recipeId, logId, ok := parseRecipePath(filePath)
+	if !ok {
+		return nil
+	}
+
+	var data []byte
+	file, err := tree.File(filePath)
+	if err == nil {
+		contents, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		data = []byte(contents)
+	} else if !errors.Is(err, object.ErrFileNotFound) {
+		return err
+	}
+
+	if logId == "" {
+		if data == nil {
+			delete(idx.recipes, recipeId)
+			delete(idx.modified, recipeId)
+		} else {
+			idx.recipes[recipeId] = data
+		}
+		return nil
+	}
+
+	if data == nil {
+		delete(idx.logs[recipeId], logId)
+		if len(idx.logs[recipeId]) == 0 {
+			delete(idx.logs, recipeId)
+		}
+		return nil
+	}
+	idx.log(recipeId, logId).data = data
+	return nil
+}
+
+// reindex updates the search index with the recipe and its logs. Recipes that can't be read are
+// left out of search, the same way they fail to load everywhere else.
+func (idx *recipeIndex) reindex(recipeId string) {
+	idx.search.Remove(recipeId)
+	data, ok := idx.recipes[recipeId]
+	if !ok {
+		return
+	}
+
+	recipe, err := decodeRecipe(bytes.NewReader(data), recipeId)
+	if err != nil {
+		slog.Warn("Failed to index recipe for search", "recipeId", recipeId, "err", err)
+		return
+	}
+	rlogs := make([]models.RecipeLog, 0, len(idx.logs[recipeId]))
+	for _, logId := range idx.logIds(recipeId) {
+		rlog, err := idx.logs[recipeId][logId].decode(logId)
+		if err != nil {
+			slog.Warn("Failed to index recipe log for search", "recipeId", recipeId, "logId", logId, "err", err)
+			continue
+		}
+		rlogs = append(rlogs, *rlog)
+	}
+	idx.search.Put(search.NewDocument(recipe, rlogs))
+}
+
+func (idx *recipeIndex) log(recipeId, logId string) *indexedLog {
+	logs, ok := idx.logs[recipeId]
+	if !ok {
+		logs = make(map[string]*indexedLog)
+		idx.logs[recipeId] = logs
+	}
+	log, ok := logs[logId]
+	if !ok {
+		log = &indexedLog{}
+		logs[logId] = log
+	}
+	return log
+}
+
+// decode reads the log, with the commits that created and last changed it
+func (log *indexedLog) decode(logId string) (*models.RecipeLog, error) {
+	var rlog models.RecipeLog
+	if err := json.Unmarshal(log.data, &rlog); err != nil {
+		return nil, err
+	}
+	rlog.Id = logId
+	rlog.CreatedCommit, rlog.Commit = copyCommit(log.created), copyCommit(log.latest)
+	return &rlog, nil
+}
+
+// recipeIds returns the ids of all indexed recipes, sorted
+func (idx *recipeIndex) recipeIds() []string {
+	ids := make([]string, 0, len(idx.recipes))
+	for id := range idx.recipes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// logIds returns the ids of the indexed logs of a recipe, sorted
+func (idx *recipeIndex) logIds(recipeId string) []string {
+	ids := make([]string, 0, len(idx.logs[recipeId]))
+	for id, log := range idx.logs[recipeId] {
+		if log.data != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// copyCommit returns a copy of the indexed commit, so callers can't change the index
+func copyCommit(commit *models.Commit) *models.Commit {
+	if commit == nil {
+		return nil
+	}
+	c := *commit
+	return &c
+}
@@ -0,0 +1,288 @@
+package database_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jonasmh/recipetracker/pkg/config"
+	"github.com/jonasmh/recipetracker/pkg/database"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+const (
+	benchRecipes = 500
+	// benchLogs logs are added to every recipe in turn, for a history of benchRecipes*(benchLogs+1) commits
+	benchLogs = 4
+	// benchRecipeId is the recipe whose logs are read. Its logs are spread over the whole history.
+	benchRecipeId = "recipe-0000"
+)
+
+// BenchmarkIndex compares reading the logs of a recipe through the index to walking the git
+// history for every log, as GetRecipeLogs did before the index. The repository is generated
+// once and shared by the sub-benchmarks.
+func BenchmarkIndex(b *testing.B) {
+	level := slog.SetLogLoggerLevel(slog.LevelWarn)
+	defer slog.SetLogLoggerLevel(level)
+	repoPath := generateRepo(b, benchRecipes, benchLogs)
+
+	// Opening the repository indexes all of its commits
+	b.Run("Build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db, err := database.New(config.GitConfig{Repository: repoPath})
+			if err != nil {
+				b.Fatal(err)
+			}
+			db.Close()
+		}
+	})
+
+	b.Run("IndexedLogs", func(b *testing.B) {
+		db, err := database.New(config.GitConfig{Repository: repoPath})
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer db.Close()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rlogs, err := db.GetRecipeLogs(benchRecipeId)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(rlogs) != benchLogs {
+				b.Fatalf("got %d logs, want %d", len(rlogs), benchLogs)
+			}
+		}
+	})
+
+	// Walking takes minutes at this size, run -bench 'Index/[BI]' to skip it
+	b.Run("WalkedLogs", func(b *testing.B) {
+		repo, err := git.PlainOpen(repoPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			rlogs, err := walkRecipeLogs(repo, benchRecipeId)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(rlogs) != benchLogs {
+				b.Fatalf("got %d logs, want %d", len(rlogs), benchLogs)
+			}
+		}
+	})
+}
+
+// walkRecipeLogs reads the logs of a recipe the way GetRecipeLogs did before the index, walking
+// the history back from HEAD for each log to find the commit that last changed it
+func walkRecipeLogs(repo *git.Repository, recipeId string) ([]models.RecipeLog, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	logsTree, err := tree.Tree("recipes/" + recipeId + "/logs")
+	if err != nil {
+		return nil, err
+	}
+
+	rlogs := make([]models.RecipeLog, 0, len(logsTree.Entries))
+	for _, entry := range logsTree.Entries {
+		file, err := logsTree.File(entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := file.Contents()
+		if err != nil {
+			return nil, err
+		}
+		var rlog models.RecipeLog
+		if err := json.Unmarshal([]byte(contents), &rlog); err != nil {
+			return nil, err
+		}
+		rlog.Id = strings.TrimSuffix(entry.Name, ".json")
+
+		fileName := "recipes/" + recipeId + "/logs/" + entry.Name
+		logIter, err := repo.Log(&git.LogOptions{FileName: &fileName})
+		if err != nil {
+			return nil, err
+		}
+		if last, err := logIter.Next(); err == nil {
+			rlog.Commit = &models.Commit{Hash: last.Hash.String(), Message: last.Message}
+		}
+		logIter.Close()
+
+		rlogs = append(rlogs, rlog)
+	}
+	return rlogs, nil
+}
+
+// generateRepo creates a repository with a commit adding each recipe, then a commit for every
+// log, going through the recipes in turn. The objects are written directly, as committing
+// through the database is too slow for thousands of commits.
+func generateRepo(b *testing.B, recipes, logs int) string {
+	b.Helper()
+	repoPath := filepath.Join(b.TempDir(), "db")
+	repo, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	g := &repoGenerator{
+		repo:       repo,
+		recipes:    make(map[string]plumbing.Hash),
+		logEntries: make(map[string][]object.TreeEntry),
+		when:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	recipeIds := make([]string, recipes)
+	for r := range recipeIds {
+		recipeIds[r] = fmt.Sprintf("recipe-%04d", r)
+		g.addRecipe(b, models.Recipe{
+			Id:          recipeIds[r],
+			Title:       fmt.Sprintf("Recipe %d", r),
+			Ingredients: []models.RecipeIngredient{{Name: "flour", Quantity: 500, Unit: "g"}},
+			Steps:       []models.RecipeStep{{Text: "Bake"}},
+		})
+	}
+	for l := 0; l < logs; l++ {
+		for _, recipeId := range recipeIds {
+			g.addLog(b, models.RecipeLog{
+				Id:          fmt.Sprintf("log-%04d", l),
+				RecipeId:    recipeId,
+				Description: "Cooked it",
+			})
+		}
+	}
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(head.Target(), g.head)); err != nil {
+		b.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := worktree.Reset(&git.ResetOptions{Mode: git.HardReset}); err != nil {
+		b.Fatal(err)
+	}
+	return repoPath
+}
+
+// repoGenerator writes commits of recipes and logs, keeping the trees of each recipe so a commit
+// only writes the trees it changes
+type repoGenerator struct {
+	repo *git.Repository
+	head plumbing.Hash
+	when time.Time
+	// recipes are the blobs of current.json
+	recipes map[string]plumbing.Hash
+	// logEntries are the entries of each recipe's logs tree, sorted
+	logEntries map[string][]object.TreeEntry
+	// recipeTrees are the trees of each recipe directory
+	recipeTrees map[string]plumbing.Hash
+}
+
+func (g *repoGenerator) addRecipe(b *testing.B, recipe models.Recipe) {
+	g.recipes[recipe.Id] = g.writeBlob(b, recipe)
+	g.commit(b, recipe.Id, "Add recipe "+recipe.Id)
+}
+
+func (g *repoGenerator) addLog(b *testing.B, rlog models.RecipeLog) {
+	g.logEntries[rlog.RecipeId] = append(g.logEntries[rlog.RecipeId], object.TreeEntry{
+		Name: rlog.Id + ".json",
+		Mode: filemode.Regular,
+		Hash: g.writeBlob(b, rlog),
+	})
+	g.commit(b, rlog.RecipeId, "Add log "+rlog.Id)
+}
+
+// commit writes the tree of the changed recipe and a commit of it
+func (g *repoGenerator) commit(b *testing.B, recipeId, message string) {
+	if g.recipeTrees == nil {
+		g.recipeTrees = make(map[string]plumbing.Hash)
+	}
+	recipeEntries := []object.TreeEntry{{Name: "current.json", Mode: filemode.Regular, Hash: g.recipes[recipeId]}}
+	if entries := g.logEntries[recipeId]; len(entries) > 0 {
+		recipeEntries = append(recipeEntries, object.TreeEntry{Name: "logs", Mode: filemode.Dir, Hash: g.writeTree(b, entries)})
+	}
+	g.recipeTrees[recipeId] = g.writeTree(b, recipeEntries)
+
+	recipesEntries := make([]object.TreeEntry, 0, len(g.recipeTrees))
+	for id, hash := range g.recipeTrees {
+		recipesEntries = append(recipesEntries, object.TreeEntry{Name: id, Mode: filemode.Dir, Hash: hash})
+	}
+	sort.Slice(recipesEntries, func(i, j int) bool { return recipesEntries[i].Name < recipesEntries[j].Name })
+	root := g.writeTree(b, []object.TreeEntry{{Name: "recipes", Mode: filemode.Dir, Hash: g.writeTree(b, recipesEntries)}})
+
+	g.when = g.when.Add(time.Minute)
+	signature := object.Signature{Name: "bench", Email: "bench@example.com", When: g.when}
+	commit := &object.Commit{Author: signature, Committer: signature, Message: message, TreeHash: root}
+	if !g.head.IsZero() {
+		commit.ParentHashes = []plumbing.Hash{g.head}
+	}
+	g.head = g.writeObject(b, commit)
+}
+
+func (g *repoGenerator) writeBlob(b *testing.B, v any) plumbing.Hash {
+	data, err := json.Marshal(v)
+	if err != nil {
+		b.Fatal(err)
+	}
+	obj := g.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	writer, err := obj.Writer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := writer.Write(append(data, '\n')); err != nil {
+		b.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		b.Fatal(err)
+	}
+	hash, err := g.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return hash
+}
+
+func (g *repoGenerator) writeTree(b *testing.B, entries []object.TreeEntry) plumbing.Hash {
+	return g.writeObject(b, &object.Tree{Entries: entries})
+}
+
+func (g *repoGenerator) writeObject(b *testing.B, o interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		b.Fatal(err)
+	}
+	hash, err := g.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return hash
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"os"
+	"time"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/ratings"
+)
+
+// ListRecipes returns the recipes with the commit time of their last change and what their logs
+// say about when they were last cooked and how they are rated
+func (db *RecipeDatabase) ListRecipes(includeArchived bool) ([]models.RecipeListing, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	listings := make([]models.RecipeListing, 0)
+	for _, id := range db.index.recipeIds() {
+		recipe, err := db.getRecipe(id)
+		if err != nil {
+			return nil, err
+		}
+		if recipe.Archived && !includeArchived {
+			continue
+		}
+		rlogs, err := db.getRecipeLogs(id)
+		if err != nil {
+			return nil, err
+		}
+
+		listing := newListing(recipe, rlogs)
+		if commit := db.index.modified[id]; commit != nil {
+			listing.LastModified = commit.Author.When
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+// ListRecipes takes the last change of a recipe from the modification time of its file, as
+// there is no history
+func (s *DirectoryStore) ListRecipes(includeArchived bool) ([]models.RecipeListing, error) {
+	recipes, err := s.GetRecipes(includeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	listings := make([]models.RecipeListing, 0, len(recipes))
+	for _, recipe := range recipes {
+		rlogs, err := s.GetRecipeLogs(recipe.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		listing := newListing(recipe, rlogs)
+		info, err := s.fs.Stat(recipesPath + recipe.Id + "/current.json")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			listing.LastModified = info.ModTime().Format(time.RFC3339)
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+func newListing(recipe models.Recipe, rlogs []models.RecipeLog) models.RecipeListing {
+	stats := ratings.Stats(rlogs)
+	return models.RecipeListing{Recipe: recipe, LastCooked: stats.LastCooked, Rating: stats.AverageRating}
+}
@@ -0,0 +1,38 @@
+//go:build !unix
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// fileLock is an exclusive lock held by creating the lock file. Unlike flock, the file is
+// left behind if the process dies, and must be removed by hand.
+type fileLock struct {
+	path string
+}
+
+func acquireFileLock(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("repository is locked by another process, remove %s if it is not running", path)
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return &fileLock{path: path}, nil
+}
+
+func (l *fileLock) release() error {
+	if l == nil || l.path == "" {
+		return nil
+	}
+	defer func() { l.path = "" }()
+
+	return os.Remove(l.path)
+}
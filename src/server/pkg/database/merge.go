@@ -0,0 +1,503 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/recipemerge"
+)
+
+// mergeAuthor is the author of merge commits, which are not made on behalf of a user
+const mergeAuthor = "recipetracker"
+
+// conflictPath is the path of a merge conflict in db.conflicts
+func conflictPath(id string) string {
+	return id + ".json"
+}
+
+// mergeRemote brings the fetched remote branch into the local branch. It fast-forwards when the
+// local branch has no commits of its own, and otherwise merges the two in a merge commit.
+func (db *RecipeDatabase) mergeRemote() error {
+	headRef, err := db.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return err
+	}
+	branch := headRef.Target()
+	if headRef.Type() != plumbing.SymbolicReference {
+		return fmt.Errorf("HEAD is detached at %s", headRef.Hash())
+	}
+
+	remoteRef, err := db.repo.Reference(plumbing.NewRemoteReferenceName("origin", branch.Short()), true)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil // Nothing on the remote yet
+		}
+		return err
+	}
+	theirs, err := db.repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return err
+	}
+
+	head, err := db.repo.Head()
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return db.fastForward(branch, theirs.Hash) // Nothing local yet
+		}
+		return err
+	}
+	if head.Hash() == theirs.Hash {
+		return nil
+	}
+	ours, err := db.repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	if behind, err := theirs.IsAncestor(ours); err != nil || behind {
+		return err // The remote has nothing we don't
+	}
+	if ahead, err := ours.IsAncestor(theirs); err != nil || ahead {
+		if err != nil {
+			return err
+		}
+		return db.fastForward(branch, theirs.Hash)
+	}
+
+	return db.merge(ours, theirs, "Merge remote changes", mergeAuthor)
+}
+
+func (db *RecipeDatabase) fastForward(branch plumbing.ReferenceName, hash plumbing.Hash) error {
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Fast-forwarding to remote", "commit", hash.String())
+	// Point the branch at the commit first, as it may not exist yet in an empty repository
+	if err := db.repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+		return err
+	}
+	return db.updateIndex(hash)
+}
+
+// merge creates a merge commit of ours and theirs, which must be HEAD. Files changed on both
+// sides are merged field by field, and what can't be merged is kept as a merge conflict to be
+// resolved later.
+func (db *RecipeDatabase) merge(ours, theirs *object.Commit, commitMessage, authorName string) (err error) {
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	var conflicts []models.MergeConflict
+	committed := false
+	defer func() {
+		if err == nil || committed {
+			return
+		}
+		for _, conflict := range conflicts {
+			if conflict.Id != "" {
+				_ = db.conflicts.Remove(conflictPath(conflict.Id))
+			}
+		}
+		err = errors.Join(err, discardChanges(worktree))
+	}()
+
+	var baseTree *object.Tree
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return err
+	}
+	if len(bases) > 0 {
+		if baseTree, err = bases[0].Tree(); err != nil {
+			return err
+		}
+	}
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return err
+	}
+	theirsTree, err := theirs.Tree()
+	if err != nil {
+		return err
+	}
+
+	oursChanged, err := changedPaths(baseTree, oursTree)
+	if err != nil {
+		return err
+	}
+	theirsChanged, err := changedPaths(baseTree, theirsTree)
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range theirsChanged {
+		theirData, err := readTreeFile(theirsTree, filePath)
+		if err != nil {
+			return err
+		}
+		if !contains(oursChanged, filePath) {
+			if err := applyFile(worktree, filePath, theirData); err != nil {
+				return err
+			}
+			continue
+		}
+
+		ourData, err := readTreeFile(oursTree, filePath)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(ourData, theirData) {
+			continue
+		}
+		baseData, err := readTreeFile(baseTree, filePath)
+		if err != nil {
+			return err
+		}
+
+		merged, conflict, err := mergeFile(filePath, baseData, ourData, theirData)
+		if err != nil {
+			return err
+		}
+		if err := applyFile(worktree, filePath, merged); err != nil {
+			return err
+		}
+		if conflict != nil {
+			conflict.OursCommit = ours.Hash.String()
+			conflict.TheirsCommit = theirs.Hash.String()
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+
+	for i := range conflicts {
+		conflicts[i].Id = newConflictId(db.conflicts)
+		if err := db.writeMergeConflict(conflicts[i]); err != nil {
+			return err
+		}
+	}
+
+	if len(conflicts) > 0 {
+		commitMessage += fmt.Sprintf("\n\n%d conflicts must be resolved:\n", len(conflicts))
+		for _, conflict := range conflicts {
+			commitMessage += fmt.Sprintf("  %s %s\n", conflict.Path, strings.Join(conflict.Fields, ", "))
+		}
+	}
+
+	slog.Info("Merging", "ours", ours.Hash.String(), "theirs", theirs.Hash.String(), "conflicts", len(conflicts))
+	hash, err := db.commit(worktree, commitMessage, authorName, ours.Hash, theirs.Hash)
+	committed = !hash.IsZero()
+	return err
+}
+
+// discardChanges resets the worktree to HEAD after a change failed partway, so what it staged is
+// not picked up by the next commit
+func discardChanges(worktree *git.Worktree) error {
+	return worktree.Reset(&git.ResetOptions{Mode: git.HardReset})
+}
+
+func (db *RecipeDatabase) writeMergeConflict(conflict models.MergeConflict) error {
+	data, err := encodeJSON(conflict)
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(db.conflicts, conflictPath(conflict.Id), data, 0644)
+}
+
+// mergeFile merges a file changed on both sides. Merged is nil if the file should be deleted.
+// When the changes can't all be merged, ours is kept for those parts, and a conflict is returned.
+func mergeFile(filePath string, base, ours, theirs []byte) (merged []byte, conflict *models.MergeConflict, err error) {
+	recipeId, logId, isRecipeFile := parseRecipePath(filePath)
+	conflict = &models.MergeConflict{
+		Path:     filePath,
+		RecipeId: recipeId,
+		LogId:    logId,
+		Fields:   make([]string, 0),
+		Base:     rawJSON(base),
+		Ours:     rawJSON(ours),
+		Theirs:   rawJSON(theirs),
+	}
+
+	switch {
+	case ours == nil:
+		// Deleted here but changed on the remote, keep the changes
+		merged = theirs
+	case theirs == nil:
+		merged = ours
+	case !isRecipeFile:
+		merged = ours
+	case logId == "":
+		var fields []string
+		merged, fields, err = mergeRecipeFile(recipeId, base, ours, theirs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(fields) == 0 {
+			return merged, nil, nil
+		}
+		conflict.Fields = fields
+	default:
+		var fields []string
+		merged, fields, err = mergeLogFile(base, ours, theirs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(fields) == 0 {
+			return merged, nil, nil
+		}
+		conflict.Fields = fields
+	}
+
+	conflict.Merged = rawJSON(merged)
+	return merged, conflict, nil
+}
+
+func mergeRecipeFile(id string, base, ours, theirs []byte) ([]byte, []string, error) {
+	var baseRecipe models.Recipe
+	if base != nil {
+		var err error
+		if baseRecipe, err = decodeRecipe(bytes.NewReader(base), id); err != nil {
+			return nil, nil, err
+		}
+	}
+	ourRecipe, err := decodeRecipe(bytes.NewReader(ours), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirRecipe, err := decodeRecipe(bytes.NewReader(theirs), id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged, fields := recipemerge.MergeRecipe(baseRecipe, ourRecipe, theirRecipe)
+	data, err := encodeJSON(merged)
+	return data, fields, err
+}
+
+func mergeLogFile(base, ours, theirs []byte) ([]byte, []string, error) {
+	var baseLog, ourLog, theirLog models.RecipeLog
+	if base != nil {
+		if err := json.Unmarshal(base, &baseLog); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := json.Unmarshal(ours, &ourLog); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(theirs, &theirLog); err != nil {
+		return nil, nil, err
+	}
+
+	merged, fields := recipemerge.MergeRecipeLog(baseLog, ourLog, theirLog)
+	data, err := encodeJSON(merged)
+	return data, fields, err
+}
+
+// encodeJSON encodes v the same way writeJSON does
+func encodeJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// rawJSON returns the file as JSON, or null if it does not exist or is not JSON
+func rawJSON(data []byte) json.RawMessage {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || !json.Valid(data) {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(data)
+}
+
+// changedPaths lists the files that differ between two trees, sorted
+func changedPaths(from, to *object.Tree) ([]string, error) {
+	changes, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if change.To.Name != "" {
+			paths = append(paths, change.To.Name)
+		} else {
+			paths = append(paths, change.From.Name)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func contains(sorted []string, s string) bool {
+	i := sort.SearchStrings(sorted, s)
+	return i < len(sorted) && sorted[i] == s
+}
+
+// readTreeFile returns the contents of the file, or nil if it does not exist
+func readTreeFile(tree *object.Tree, filePath string) ([]byte, error) {
+	if tree == nil {
+		return nil, nil
+	}
+	file, err := tree.File(filePath)
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+// applyFile writes the file to the worktree and stages it, or removes it if data is nil
+func applyFile(worktree *git.Worktree, filePath string, data []byte) error {
+	if data == nil {
+		if _, err := worktree.Filesystem.Stat(filePath); os.IsNotExist(err) {
+			return nil
+		}
+		_, err := worktree.Remove(filePath)
+		return err
+	}
+
+	if err := worktree.Filesystem.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	if err := util.WriteFile(worktree.Filesystem, filePath, data, 0644); err != nil {
+		return err
+	}
+	_, err := worktree.Add(filePath)
+	return err
+}
+
+// GetMergeConflicts returns the unresolved conflicts from merging with the remote, oldest first
+func (db *RecipeDatabase) GetMergeConflicts() ([]models.MergeConflict, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	conflicts := make([]models.MergeConflict, 0)
+	dirInfo, err := db.conflicts.ReadDir(".")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return conflicts, nil
+		}
+		return nil, err
+	}
+	sort.Slice(dirInfo, func(i, j int) bool { return dirInfo[i].Name() < dirInfo[j].Name() })
+
+	for _, file := range dirInfo {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		conflict, err := db.getMergeConflict(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, nil
+}
+
+func (db *RecipeDatabase) GetMergeConflict(id string) (models.MergeConflict, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getMergeConflict(id)
+}
+
+func (db *RecipeDatabase) getMergeConflict(id string) (conflict models.MergeConflict, err error) {
+	file, err := db.conflicts.Open(conflictPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return conflict, fmt.Errorf("%w: %s", ErrMergeConflictNotFound, id)
+		}
+		return conflict, err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&conflict); err != nil {
+		return conflict, err
+	}
+	conflict.Id = id
+	return conflict, nil
+}
+
+// ResolveMergeConflict replaces the conflicting file with the resolved contents, or deletes it
+// if resolved is JSON null, and removes the conflict once that is committed. Files that are not
+// recipes or logs keep the merged version, and the conflict is just removed.
+func (db *RecipeDatabase) ResolveMergeConflict(id string, resolved json.RawMessage, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	conflict, err := db.getMergeConflict(id)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if conflict.RecipeId != "" && !bytes.Equal(bytes.TrimSpace(resolved), []byte("null")) {
+		// Decode and encode again, so the file is stored like any other
+		var v any = &models.Recipe{}
+		if conflict.LogId != "" {
+			v = &models.RecipeLog{}
+		}
+		if err := json.Unmarshal(resolved, v); err != nil {
+			return fmt.Errorf("%w: resolved %s is not valid: %s", ErrInvalid, conflict.Path, err)
+		}
+		switch v := v.(type) {
+		case *models.Recipe:
+			v.Id = conflict.RecipeId
+		case *models.RecipeLog:
+			v.Id = conflict.LogId
+			v.RecipeId = conflict.RecipeId
+			v.Commit = nil
+			v.CreatedCommit = nil
+		}
+		if data, err = encodeJSON(v); err != nil {
+			return err
+		}
+	}
+
+	if conflict.RecipeId == "" {
+		return db.conflicts.Remove(conflictPath(id))
+	}
+
+	if err := applyFile(worktree, conflict.Path, data); err != nil {
+		return errors.Join(err, discardChanges(worktree))
+	}
+	if commitMessage == "" {
+		commitMessage = "Resolve merge conflict in " + conflict.Path
+	}
+	hash, err := db.commit(worktree, commitMessage, authorName)
+	if hash.IsZero() {
+		if errors.Is(err, git.ErrEmptyCommit) {
+			// The file already is as resolved
+			return db.conflicts.Remove(conflictPath(id))
+		}
+		return errors.Join(err, discardChanges(worktree))
+	}
+	return errors.Join(err, db.conflicts.Remove(conflictPath(id)))
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jonasmh/recipetracker/pkg/images"
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// logPhotosPath is the directory with the photos of a log, next to the log file
+func logPhotosPath(recipeId, logId string) string {
+	return recipesPath + recipeId + "/logs/" + logId + "/"
+}
+
+// photoFiles lists the photos of a log with their file names, oldest first
+func photoFiles(fs billy.Filesystem, recipeId, logId string) ([]models.LogPhoto, []string, error) {
+	dirInfo, err := fs.ReadDir(logPhotosPath(recipeId, logId))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	sort.Slice(dirInfo, func(i, j int) bool { return dirInfo[i].Name() < dirInfo[j].Name() })
+
+	photos := make([]models.LogPhoto, 0, len(dirInfo))
+	names := make([]string, 0, len(dirInfo))
+	for _, file := range dirInfo {
+		extension := path.Ext(file.Name())
+		contentType := images.ContentType(extension)
+		if file.IsDir() || contentType == "" {
+			continue
+		}
+		photos = append(photos, models.LogPhoto{
+			Id:          strings.TrimSuffix(file.Name(), extension),
+			RecipeId:    recipeId,
+			LogId:       logId,
+			ContentType: contentType,
+			Size:        file.Size(),
+		})
+		names = append(names, file.Name())
+	}
+	return photos, names, nil
+}
+
+func listPhotos(fs billy.Filesystem, recipeId, logId string) ([]models.LogPhoto, error) {
+	photos, _, err := photoFiles(fs, recipeId, logId)
+	return photos, err
+}
+
+// findPhoto returns the photo and the path of its file
+func findPhoto(fs billy.Filesystem, recipeId, logId, photoId string) (models.LogPhoto, string, error) {
+	photos, names, err := photoFiles(fs, recipeId, logId)
+	if err != nil {
+		return models.LogPhoto{}, "", err
+	}
+	for i, photo := range photos {
+		if photo.Id == photoId {
+			return photo, logPhotosPath(recipeId, logId) + names[i], nil
+		}
+	}
+	return models.LogPhoto{}, "", fmt.Errorf("%w: %s/%s/%s", ErrPhotoNotFound, recipeId, logId, photoId)
+}
+
+func readPhoto(fs billy.Filesystem, recipeId, logId, photoId string) (models.LogPhoto, []byte, error) {
+	photo, filePath, err := findPhoto(fs, recipeId, logId, photoId)
+	if err != nil {
+		return photo, nil, err
+	}
+	data, err := util.ReadFile(fs, filePath)
+	return photo, data, err
+}
+
+// newPhoto checks the uploaded image, and returns the photo with the path to store it at
+func newPhoto(fs billy.Filesystem, recipeId, logId string, data []byte) (models.LogPhoto, string, error) {
+	contentType, extension, err := images.Sniff(data)
+	if err != nil {
+		return models.LogPhoto{}, "", fmt.Errorf("%w: %s", ErrInvalidPhoto, err)
+	}
+
+	photo := models.LogPhoto{
+		RecipeId:    recipeId,
+		LogId:       logId,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+	photo.Id = newTimeId(func(id string) bool {
+		_, _, err := findPhoto(fs, recipeId, logId, id)
+		return err == nil
+	})
+	return photo, logPhotosPath(recipeId, logId) + photo.Id + extension, nil
+}
+
+func (db *RecipeDatabase) GetLogPhotos(recipeId, logId string) ([]models.LogPhoto, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, err := db.getRecipeLog(recipeId, logId); err != nil {
+		return nil, err
+	}
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	return listPhotos(worktree.Filesystem, recipeId, logId)
+}
+
+func (db *RecipeDatabase) GetLogPhoto(recipeId, logId, photoId string) (models.LogPhoto, []byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return models.LogPhoto{}, nil, err
+	}
+	return readPhoto(worktree.Filesystem, recipeId, logId, photoId)
+}
+
+// AddLogPhoto stores a JPEG, PNG, GIF or WebP image next to the log
+func (db *RecipeDatabase) AddLogPhoto(recipeId, logId string, data []byte, commitMessage, authorName string) (models.LogPhoto, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.getRecipeLog(recipeId, logId); err != nil {
+		return models.LogPhoto{}, err
+	}
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return models.LogPhoto{}, err
+	}
+
+	photo, filePath, err := newPhoto(worktree.Filesystem, recipeId, logId, data)
+	if err != nil {
+		return photo, err
+	}
+	if err := applyFile(worktree, filePath, data); err != nil {
+		return photo, err
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Add photo %s to log %s", photo.Id, logId)
+	}
+	if _, err := db.commit(worktree, commitMessage, authorName); err != nil {
+		return photo, err
+	}
+	return photo, nil
+}
+
+func (db *RecipeDatabase) DeleteLogPhoto(recipeId, logId, photoId string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	_, filePath, err := findPhoto(worktree.Filesystem, recipeId, logId, photoId)
+	if err != nil {
+		return err
+	}
+	if _, err := worktree.Remove(filePath); err != nil {
+		return err
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Delete photo %s from log %s", photoId, logId)
+	}
+	_, err = db.commit(worktree, commitMessage, authorName)
+	return err
+}
+
+func (s *DirectoryStore) GetLogPhotos(recipeId, logId string) ([]models.LogPhoto, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.getRecipeLog(recipeId, logId); err != nil {
+		return nil, err
+	}
+	return listPhotos(s.fs, recipeId, logId)
+}
+
+func (s *DirectoryStore) GetLogPhoto(recipeId, logId, photoId string) (models.LogPhoto, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return readPhoto(s.fs, recipeId, logId, photoId)
+}
+
+func (s *DirectoryStore) AddLogPhoto(recipeId, logId string, data []byte, commitMessage, authorName string) (models.LogPhoto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getRecipeLog(recipeId, logId); err != nil {
+		return models.LogPhoto{}, err
+	}
+	photo, filePath, err := newPhoto(s.fs, recipeId, logId, data)
+	if err != nil {
+		return photo, err
+	}
+	return photo, s.writeBytes(filePath, data)
+}
+
+func (s *DirectoryStore) DeleteLogPhoto(recipeId, logId, photoId string, commitMessage, authorName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, filePath, err := findPhoto(s.fs, recipeId, logId, photoId)
+	if err != nil {
+		return err
+	}
+	return s.fs.Remove(filePath)
+}
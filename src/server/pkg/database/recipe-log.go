@@ -1,188 +1,290 @@
 package database
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
-	"time"
+	"strings"
 
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/jonasmh/recipetracker/pkg/drift"
 	"github.com/jonasmh/recipetracker/pkg/models"
 )
 
-func (db *RecipeDatabase) AddRecipeLog(rlog models.RecipeLog, commitMessage, authourName string) error {
-	repo, err := git.PlainOpen(db.config.Repository)
-	if err != nil {
-		return err
+func recipeLogPath(recipeId, logId string) string {
+	return recipesPath + recipeId + "/logs/" + logId + ".json"
+}
+
+// AddRecipeLog stores a new log of an existing recipe. If the log has no id, a time-sortable id is generated.
+func (db *RecipeDatabase) AddRecipeLog(rlog models.RecipeLog, commitMessage, authourName string) (models.RecipeLog, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.getRecipe(rlog.RecipeId); err != nil {
+		return rlog, err
 	}
 
-	worktree, err := repo.Worktree()
+	worktree, err := db.repo.Worktree()
 	if err != nil {
-		return err
+		return rlog, err
 	}
 
-	filePath := recipesPath + rlog.RecipeId + "/logs/" + rlog.Id + ".json"
-	if _, err := worktree.Filesystem.Stat(recipesPath + rlog.Id); os.IsNotExist(err) {
-		if err := worktree.Filesystem.MkdirAll(recipesPath+rlog.Id, 0755); err != nil {
-			return err
+	if rlog.Id == "" {
+		rlog.Id = newLogId(worktree.Filesystem, rlog.RecipeId)
+	}
+
+	filePath := recipeLogPath(rlog.RecipeId, rlog.Id)
+	if _, err := worktree.Filesystem.Stat(filePath); err == nil {
+		return rlog, fmt.Errorf("%w: %s/%s", ErrLogExists, rlog.RecipeId, rlog.Id)
+	}
+
+	// Reset commits to nil, as they will be set by the git commit
+	rlog.Commit = nil
+	rlog.CreatedCommit = nil
+	if err := writeJSON(worktree, filePath, rlog); err != nil {
+		return rlog, err
+	}
+
+	if commitMessage == "" {
+		commitMessage = "Add log " + rlog.Id
+	}
+	if _, err := db.commit(worktree, commitMessage, authourName); err != nil {
+		return rlog, err
+	}
+
+	return rlog, nil
+}
+
+func (db *RecipeDatabase) GetRecipeLog(recipeId string, logId string) (*models.RecipeLog, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getRecipeLog(recipeId, logId)
+}
+
+func (db *RecipeDatabase) getRecipeLog(recipeId string, logId string) (*models.RecipeLog, error) {
+	indexed, ok := db.index.logs[recipeId][logId]
+	if !ok || indexed.data == nil {
+		return nil, fmt.Errorf("%w: %s/%s", ErrLogNotFound, recipeId, logId)
+	}
+
+	return indexed.decode(logId)
+}
+
+func (db *RecipeDatabase) GetRecipeLogs(recipeId string) ([]models.RecipeLog, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getRecipeLogs(recipeId)
+}
+
+func (db *RecipeDatabase) getRecipeLogs(recipeId string) ([]models.RecipeLog, error) {
+	rlogs := make([]models.RecipeLog, 0)
+	for _, logId := range db.index.logIds(recipeId) {
+		rlog, err := db.getRecipeLog(recipeId, logId)
+		if err != nil {
+			return nil, err
 		}
+
+		rlogs = append(rlogs, *rlog)
 	}
 
-	file, err := worktree.Filesystem.Create(filePath)
+	return rlogs, nil
+}
+
+func (db *RecipeDatabase) DeleteRecipeLog(recipeId string, logId string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	worktree, err := db.repo.Worktree()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	rlog.Commit = nil // Reset commit to nil, as it will be set by the git commit
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(rlog); err != nil {
-		return err
+	filePath := recipeLogPath(recipeId, logId)
+	if _, err := worktree.Filesystem.Stat(filePath); os.IsNotExist(err) {
+		return nil // File does not exist, nothing to delete
 	}
 
-	if _, err := worktree.Add(filePath); err != nil {
+	if _, err := worktree.Remove(filePath); err != nil {
 		return err
 	}
+	// The photos of the log go with it
+	if _, err := worktree.Filesystem.Stat(logPhotosPath(recipeId, logId)); err == nil {
+		if _, err := worktree.Remove(logPhotosPath(recipeId, logId)); err != nil {
+			return err
+		}
+		if err := util.RemoveAll(worktree.Filesystem, logPhotosPath(recipeId, logId)); err != nil {
+			return err
+		}
+	}
 
-	if _, err := worktree.Commit(commitMessage, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  authourName,
-			Email: db.getCommitEmail(),
-			When:  time.Now(),
-		},
-	}); err != nil {
+	if commitMessage == "" {
+		commitMessage = "Delete log " + logId
+	}
+	if _, err := db.commit(worktree, commitMessage, authorName); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (db *RecipeDatabase) GetRecipeLog(recipeId string, logId string) (*models.RecipeLog, error) {
-	repo, err := git.PlainOpen(db.config.Repository)
+// UpdateRecipeLog overwrites an existing log, keeping its PromotedCommit. When lastCommit is set,
+// the update is rejected with ErrLogModified if the log has been changed by another commit since.
+func (db *RecipeDatabase) UpdateRecipeLog(rlog models.RecipeLog, lastCommit string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	worktree, err := db.repo.Worktree()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	worktree, err := repo.Worktree()
-	if err != nil {
-		return nil, err
+	filePath := recipeLogPath(rlog.RecipeId, rlog.Id)
+	if _, err := worktree.Filesystem.Stat(filePath); os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s/%s", ErrLogNotFound, rlog.RecipeId, rlog.Id)
 	}
 
-	recipeLogFileName := recipesPath + recipeId + "/logs/" + logId + ".json"
-	f, err := worktree.Filesystem.Open(recipeLogFileName)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	var rlog models.RecipeLog
-	if err := json.NewDecoder(f).Decode(&rlog); err != nil {
-		return nil, err
-	}
-	rlog.Id = logId
-	// Get the commit of when this file was last changed
-	logIter, err := repo.Log(&git.LogOptions{FileName: &recipeLogFileName})
-	if err == nil {
-		defer logIter.Close()
-		commit, err := logIter.Next()
-		if err == nil && commit != nil {
-			commitModel := convertToCommitModel(commit)
-			rlog.Commit = &commitModel
+	if lastCommit != "" {
+		if indexed, ok := db.index.logs[rlog.RecipeId][rlog.Id]; ok && indexed.latest != nil && indexed.latest.Hash != lastCommit {
+			return fmt.Errorf("%w: last commit is %s", ErrLogModified, indexed.latest.Hash)
 		}
 	}
 
-	return &rlog, nil
-}
-
-func (db *RecipeDatabase) GetRecipeLogs(recipeId string) ([]models.RecipeLog, error) {
-	repo, err := git.PlainOpen(db.config.Repository)
+	// The promotion is recorded by PromoteRecipeLog, not by clients
+	stored, err := db.getRecipeLog(rlog.RecipeId, rlog.Id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	worktree, err := repo.Worktree()
-	if err != nil {
-		return nil, err
+	rlog.PromotedCommit = stored.PromotedCommit
+	rlog.Commit = nil
+	rlog.CreatedCommit = nil
+	if err := writeJSON(worktree, filePath, rlog); err != nil {
+		return err
 	}
-	rlogs := make([]models.RecipeLog, 0)
 
-	recipeLogsPath := recipesPath + recipeId + "/logs/"
+	if commitMessage == "" {
+		commitMessage = "Update log " + rlog.Id
+	}
+	if _, err := db.commit(worktree, commitMessage, authorName); err != nil {
+		return err
+	}
 
-	dirInfo, err := worktree.Filesystem.ReadDir(recipeLogsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return rlogs, nil
-		}
+	return nil
+}
 
-		return nil, err
+// promoteIngredients makes the actual ingredients of the log the ingredients of the recipe.
+// Ingredients the recipe marks as unscalable stay unscalable.
+func promoteIngredients(recipe models.Recipe, rlog models.RecipeLog) (models.Recipe, error) {
+	if len(rlog.ActualIngredients) == 0 {
+		return recipe, fmt.Errorf("%w: %s/%s", ErrNothingToPromote, rlog.RecipeId, rlog.Id)
 	}
 
-	for _, logFile := range dirInfo {
-		if logFile.IsDir() {
-			continue
-		}
-		recipeLogFileName := recipeLogsPath + logFile.Name()
-		f, err := worktree.Filesystem.Open(recipeLogFileName)
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
-
-		var rlog models.RecipeLog
-		if err := json.NewDecoder(f).Decode(&rlog); err != nil {
-			return nil, err
-		}
-		rlog.Id = logFile.Name()[:len(logFile.Name())-len(".json")]
-		// Get the commit of when this file was last changed
-		logIter, err := repo.Log(&git.LogOptions{FileName: &recipeLogFileName})
-		if err == nil {
-			defer logIter.Close()
-			commit, err := logIter.Next()
-			if err == nil && commit != nil {
-				commitModel := convertToCommitModel(commit)
-				rlog.Commit = &commitModel
-			}
+	unscalable := make(map[string]bool)
+	for _, ingredient := range recipe.Ingredients {
+		if ingredient.Unscalable {
+			unscalable[strings.ToLower(strings.TrimSpace(ingredient.Name))] = true
 		}
-
-		rlogs = append(rlogs, rlog)
 	}
 
-	return rlogs, nil
+	recipe.Ingredients = make([]models.RecipeIngredient, len(rlog.ActualIngredients))
+	for i, ingredient := range rlog.ActualIngredients {
+		ingredient.Unscalable = ingredient.Unscalable || unscalable[strings.ToLower(strings.TrimSpace(ingredient.Name))]
+		recipe.Ingredients[i] = ingredient
+	}
+	return recipe, nil
 }
 
-func (db *RecipeDatabase) DeleteRecipeLog(recipeId string, logId string, commitMessage, authorName string) error {
-	repo, err := git.PlainOpen(db.config.Repository)
+// PromoteRecipeLog applies the actual ingredients of the log to the recipe, and records the
+// resulting recipe commit in the log
+func (db *RecipeDatabase) PromoteRecipeLog(recipeId string, logId string, commitMessage, authorName string) (models.Recipe, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	recipe, err := db.getRecipe(recipeId)
 	if err != nil {
-		return err
+		return recipe, err
 	}
-
-	worktree, err := repo.Worktree()
+	rlog, err := db.getRecipeLog(recipeId, logId)
 	if err != nil {
-		return err
+		return recipe, err
+	}
+	recipe, err = promoteIngredients(recipe, *rlog)
+	if err != nil {
+		return recipe, err
 	}
 
-	filePath := recipesPath + recipeId + "/logs/" + logId + ".json"
-	if _, err := worktree.Filesystem.Stat(filePath); os.IsNotExist(err) {
-		return nil // File does not exist, nothing to delete
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Update recipe %s from log %s", recipeId, logId)
+	} else {
+		commitMessage = fmt.Sprintf("%s\n\nFrom log %s", commitMessage, logId)
+	}
+	if err := db.addOrUpdateRecipe(recipe, commitMessage, authorName); err != nil {
+		return recipe, err
 	}
 
-	if err := worktree.Filesystem.Remove(filePath); err != nil {
-		return err
+	head, err := db.repo.Head()
+	if err != nil {
+		return recipe, err
+	}
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return recipe, err
 	}
 
-	if _, err := worktree.Add(filePath); err != nil {
-		return err
+	rlog.PromotedCommit = head.Hash().String()
+	rlog.Commit = nil
+	rlog.CreatedCommit = nil
+	if err := writeJSON(worktree, recipeLogPath(recipeId, logId), rlog); err != nil {
+		return recipe, err
+	}
+	if _, err := db.commit(worktree, fmt.Sprintf("Record promotion of log %s in %s", logId, rlog.PromotedCommit), authorName); err != nil {
+		return recipe, err
 	}
 
-	if _, err := worktree.Commit(commitMessage, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  authorName,
-			Email: db.getCommitEmail(),
-			When:  time.Now(),
-		},
-	}); err != nil {
-		return err
+	return recipe, nil
+}
+
+// GetRecipeDrift compares the actual ingredients of each log with the version of the recipe that
+// was current when the log was created
+func (db *RecipeDatabase) GetRecipeDrift(recipeId string) (models.RecipeDrift, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	current, err := db.getRecipe(recipeId)
+	if err != nil {
+		return models.RecipeDrift{}, err
+	}
+	rlogs, err := db.getRecipeLogs(recipeId)
+	if err != nil {
+		return models.RecipeDrift{}, err
 	}
 
-	return nil
+	// Many logs are written against the same version, so only read each version once
+	versions := make(map[string]models.Recipe)
+	samples := make([]drift.Sample, 0, len(rlogs))
+	for _, rlog := range rlogs {
+		if len(rlog.ActualIngredients) == 0 {
+			continue
+		}
+		recipe := current
+		if rlog.CreatedCommit != nil {
+			version, ok := versions[rlog.CreatedCommit.Hash]
+			if !ok {
+				version, err = db.getRecipeVersion(recipeId, rlog.CreatedCommit.Hash)
+				if err != nil && !errors.Is(err, ErrVersionNotFound) {
+					return models.RecipeDrift{}, err
+				}
+				if err != nil {
+					// The log was added before the recipe, e.g. in a merge
+					version = current
+				}
+				versions[rlog.CreatedCommit.Hash] = version
+			}
+			recipe = version
+		}
+		samples = append(samples, drift.Sample{Log: rlog, Recipe: recipe})
+	}
+
+	return drift.Analyze(current, samples), nil
 }
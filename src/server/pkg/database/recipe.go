@@ -1,13 +1,21 @@
 package database
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path"
 	"time"
 
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/recipediff"
 )
 
 const (
@@ -31,17 +39,41 @@ func convertToCommitModel(commit *object.Commit) models.Commit {
 	}
 }
 
+// decodeRecipe reads a current.json file
+func decodeRecipe(r io.Reader, id string) (model models.Recipe, err error) {
+	if err := json.NewDecoder(r).Decode(&model); err != nil {
+		return model, err
+	}
+	model.Id = id
+	if model.Steps == nil {
+		// Recipes stored before steps were introduced have none
+		model.Steps = make([]models.RecipeStep, 0)
+	}
+	return model, nil
+}
+
 func (db *RecipeDatabase) GetRecipeHistory(id string) (history []models.Commit, err error) {
-	repo, err := git.PlainOpen(db.config.Repository)
-	if err != nil {
-		return nil, err
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getRecipeHistory(id)
+}
+
+func (db *RecipeDatabase) getRecipeHistory(id string) (history []models.Commit, err error) {
+	// Follow the recipe across renames, so history from before a rename stays reachable
+	filePaths := map[string]bool{recipesPath + id + "/current.json": true}
+	if recipe, err := db.getRecipe(id); err == nil {
+		for _, previousId := range recipe.PreviousIds {
+			filePaths[recipesPath+previousId+"/current.json"] = true
+		}
 	}
 
-	filePath := recipesPath + id + "/current.json"
-	logIter, err := repo.Log(&git.LogOptions{FileName: &filePath})
+	logIter, err := db.repo.Log(&git.LogOptions{PathFilter: func(filePath string) bool {
+		return filePaths[filePath]
+	}})
 	if err != nil {
-		if os.IsNotExist(err) {
-			return make([]models.Commit, 0), nil
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return make([]models.Commit, 0), nil // Empty repository
 		}
 
 		return nil, err
@@ -51,7 +83,7 @@ func (db *RecipeDatabase) GetRecipeHistory(id string) (history []models.Commit,
 	for {
 		commit, err := logIter.Next()
 		if err != nil {
-			if err.Error() == "EOF" {
+			if err == io.EOF {
 				break
 			}
 			return nil, err
@@ -63,122 +95,393 @@ func (db *RecipeDatabase) GetRecipeHistory(id string) (history []models.Commit,
 }
 
 func (db *RecipeDatabase) GetRecipe(name string) (model models.Recipe, err error) {
-	repo, err := git.PlainOpen(db.config.Repository)
-	if err != nil {
-		return model, err
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getRecipe(name)
+}
+
+func (db *RecipeDatabase) getRecipe(name string) (model models.Recipe, err error) {
+	data, ok := db.index.recipes[name]
+	if !ok {
+		return model, fmt.Errorf("%w: %s", ErrRecipeNotFound, name)
 	}
 
-	worktree, err := repo.Worktree()
-	if err != nil {
-		return model, err
+	return decodeRecipe(bytes.NewReader(data), name)
+}
+
+func (db *RecipeDatabase) GetRecipes(includeArchived bool) ([]models.Recipe, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	recipes := make([]models.Recipe, 0)
+	for _, id := range db.index.recipeIds() {
+		recipe, err := db.getRecipe(id)
+		if err != nil {
+			return nil, err
+		}
+		if recipe.Archived && !includeArchived {
+			continue
+		}
+
+		recipes = append(recipes, recipe)
 	}
 
-	filePath := recipesPath + name + "/current.json"
-	file, err := worktree.Filesystem.Open(filePath)
+	return recipes, nil
+}
+
+// CreateRecipe stores a new recipe. If the recipe has no id, one is generated from the title.
+// Returns ErrRecipeExists if a recipe with the given id already exists.
+func (db *RecipeDatabase) CreateRecipe(recipe models.Recipe, commitMessage, authorName string) (models.Recipe, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	worktree, err := db.repo.Worktree()
 	if err != nil {
-		return model, err
+		return recipe, err
 	}
-	defer file.Close()
 
-	if err := json.NewDecoder(file).Decode(&model); err != nil {
-		return model, err
+	if recipe.Id == "" {
+		recipe.Id = uniqueRecipeId(worktree.Filesystem, recipe.Title)
+	} else if _, err := worktree.Filesystem.Stat(recipesPath + recipe.Id); err == nil {
+		return recipe, fmt.Errorf("%w: %s", ErrRecipeExists, recipe.Id)
 	}
-	model.Id = name
 
-	return model, nil
+	if commitMessage == "" {
+		commitMessage = "Add recipe " + recipe.Id
+	}
+	if err := db.addOrUpdateRecipe(recipe, commitMessage, authorName); err != nil {
+		return recipe, err
+	}
+
+	return recipe, nil
 }
 
-func (db *RecipeDatabase) GetRecipes() ([]models.Recipe, error) {
-	repo, err := git.PlainOpen(db.config.Repository)
+// writeJSON encodes v to the file in the worktree and stages it
+func writeJSON(worktree *git.Worktree, filePath string, v any) error {
+	if err := worktree.Filesystem.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	file, err := worktree.Filesystem.Create(filePath)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
 	}
 
-	worktree, err := repo.Worktree()
+	_, err = worktree.Add(filePath)
+	return err
+}
+
+// UpdateRecipe replaces an existing recipe, keeping the fields that are only changed by renaming,
+// forking and archiving it
+func (db *RecipeDatabase) UpdateRecipe(recipe models.Recipe, commitMessage, authorName string) (models.Recipe, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, err := db.getRecipe(recipe.Id)
 	if err != nil {
-		return nil, err
+		return recipe, err
 	}
-	recipes := make([]models.Recipe, 0)
+	recipe = keepManagedFields(recipe, stored)
 
-	dirInfo, err := worktree.Filesystem.ReadDir(recipesPath)
+	if commitMessage == "" {
+		commitMessage = "Update recipe " + recipe.Id
+	}
+	return recipe, db.addOrUpdateRecipe(recipe, commitMessage, authorName)
+}
+
+// keepManagedFields returns the recipe with the fields managed by the store taken from stored
+func keepManagedFields(recipe, stored models.Recipe) models.Recipe {
+	recipe.PreviousIds = stored.PreviousIds
+	recipe.ForkedFrom = stored.ForkedFrom
+	recipe.Archived = stored.Archived
+	return recipe
+}
+
+func (db *RecipeDatabase) addOrUpdateRecipe(recipe models.Recipe, commitMessage, authourName string) error {
+	worktree, err := db.repo.Worktree()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return recipes, nil
-		}
+		return err
+	}
 
-		return nil, err
+	if err := writeJSON(worktree, recipesPath+recipe.Id+"/current.json", recipe); err != nil {
+		return err
 	}
 
-	for _, dir := range dirInfo {
-		if !dir.IsDir() {
-			continue
-		}
+	if _, err := db.commit(worktree, commitMessage, authourName); err != nil {
+		return err
+	}
 
-		filePath := recipesPath + dir.Name() + "/current.json"
-		f, err := worktree.Filesystem.Open(filePath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				continue // Skip if the current.json file does not exist
-			}
+	return nil
+}
 
-			return nil, err
-		}
-		defer f.Close()
+// DeleteRecipe removes the recipe and all of its logs in a single commit
+func (db *RecipeDatabase) DeleteRecipe(id string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
-		var recipe models.Recipe
-		if err := json.NewDecoder(f).Decode(&recipe); err != nil {
-			return nil, err
-		}
-		recipe.Id = dir.Name()
+	worktree, err := db.repo.Worktree()
+	if err != nil {
+		return err
+	}
 
-		recipes = append(recipes, recipe)
+	recipePath := recipesPath + id
+	if _, err := worktree.Filesystem.Stat(recipePath); os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrRecipeNotFound, id)
 	}
 
-	return recipes, nil
+	if _, err := worktree.Remove(recipePath); err != nil {
+		return err
+	}
+	// Remove leftovers not tracked by git, so the directory does not linger
+	if err := util.RemoveAll(worktree.Filesystem, recipePath); err != nil {
+		return err
+	}
+
+	if commitMessage == "" {
+		commitMessage = "Delete recipe " + id
+	}
+	if _, err := db.commit(worktree, commitMessage, authorName); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (db *RecipeDatabase) AddOrUpdateRecipe(recipe models.Recipe, commitMessage, authourName string) error {
-	repo, err := git.PlainOpen(db.config.Repository)
+// RenameRecipe moves the recipe and its logs from recipes/<oldId>/ to recipes/<newId>/.
+// The old id is recorded in the recipe, so its history from before the rename stays reachable.
+func (db *RecipeDatabase) RenameRecipe(oldId, newId string, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	recipe, err := db.getRecipe(oldId)
 	if err != nil {
 		return err
 	}
 
-	worktree, err := repo.Worktree()
+	worktree, err := db.repo.Worktree()
 	if err != nil {
 		return err
 	}
 
-	filePath := recipesPath + recipe.Id + "/current.json"
-	if _, err := worktree.Filesystem.Stat(recipesPath + recipe.Id); os.IsNotExist(err) {
-		if err := worktree.Filesystem.MkdirAll(recipesPath+recipe.Id, 0755); err != nil {
-			return err
-		}
+	oldPath := recipesPath + oldId
+	newPath := recipesPath + newId
+	if _, err := worktree.Filesystem.Stat(newPath); err == nil {
+		return fmt.Errorf("%w: %s", ErrRecipeExists, newId)
 	}
 
-	file, err := worktree.Filesystem.Create(filePath)
+	var files []string
+	err = util.Walk(worktree.Filesystem, oldPath, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, filePath)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(recipe); err != nil {
+	for _, file := range files {
+		target := newPath + file[len(oldPath):]
+		if err := worktree.Filesystem.MkdirAll(path.Dir(target), 0755); err != nil {
+			return err
+		}
+		if _, err := worktree.Move(file, target); err != nil {
+			return err
+		}
+	}
+	if err := util.RemoveAll(worktree.Filesystem, oldPath); err != nil {
 		return err
 	}
 
-	if _, err := worktree.Add(filePath); err != nil {
+	recipe.Id = newId
+	recipe.PreviousIds = append(recipe.PreviousIds, oldId)
+	if err := writeJSON(worktree, newPath+"/current.json", recipe); err != nil {
 		return err
 	}
+	// The logs name the recipe they belong to, so they are moved over to the new id as well
+	for _, logId := range db.index.logIds(oldId) {
+		rlog, err := db.getRecipeLog(oldId, logId)
+		if err != nil {
+			return err
+		}
+		rlog.RecipeId = newId
+		rlog.Commit = nil
+		rlog.CreatedCommit = nil
+		if err := writeJSON(worktree, recipeLogPath(newId, logId), rlog); err != nil {
+			return err
+		}
+	}
 
-	if _, err := worktree.Commit(commitMessage, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  authourName,
-			Email: db.getCommitEmail(),
-			When:  time.Now(),
-		},
-	}); err != nil {
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Rename recipe %s to %s", oldId, newId)
+	}
+	if _, err := db.commit(worktree, commitMessage, authorName); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// SetRecipeArchived archives or unarchives the recipe. Archived recipes are hidden from GetRecipes.
+func (db *RecipeDatabase) SetRecipeArchived(id string, archived bool, commitMessage, authorName string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	recipe, err := db.getRecipe(id)
+	if err != nil {
+		return err
+	}
+
+	if commitMessage == "" {
+		if archived {
+			commitMessage = "Archive recipe " + id
+		} else {
+			commitMessage = "Unarchive recipe " + id
+		}
+	}
+
+	recipe.Archived = archived
+	return db.addOrUpdateRecipe(recipe, commitMessage, authorName)
+}
+
+// GetRecipeVersion reads the recipe as it was at the given commit
+func (db *RecipeDatabase) GetRecipeVersion(id string, hash string) (model models.Recipe, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.getRecipeVersion(id, hash)
+}
+
+func (db *RecipeDatabase) getRecipeVersion(id string, hash string) (model models.Recipe, err error) {
+	if !plumbing.IsHash(hash) {
+		return model, fmt.Errorf("%w: %q is not a commit hash", ErrInvalidVersion, hash)
+	}
+	commit, err := db.repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return model, fmt.Errorf("%w: %s", ErrVersionNotFound, hash)
+		}
+		return model, err
+	}
+
+	// The recipe may have been stored under a previous id at that commit
+	ids := []string{id}
+	if current, err := db.getRecipe(id); err == nil {
+		ids = append(ids, current.PreviousIds...)
+	}
+
+	for _, candidate := range ids {
+		file, err := commit.File(recipesPath + candidate + "/current.json")
+		if err != nil {
+			if errors.Is(err, object.ErrFileNotFound) {
+				continue
+			}
+			return model, err
+		}
+
+		reader, err := file.Reader()
+		if err != nil {
+			return model, err
+		}
+		defer reader.Close()
+
+		return decodeRecipe(reader, id)
+	}
+
+	return model, fmt.Errorf("%w: %s at %s", ErrVersionNotFound, id, hash)
+}
+
+// RestoreRecipeVersion writes the recipe as it was at the given commit back as a new commit
+func (db *RecipeDatabase) RestoreRecipeVersion(id string, hash string, commitMessage, authorName string) (models.Recipe, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	recipe, err := db.getRecipeVersion(id, hash)
+	if err != nil {
+		return recipe, err
+	}
+
+	// Keep the rename trail of the current recipe, so history stays reachable after restoring
+	if current, err := db.getRecipe(id); err == nil {
+		recipe.PreviousIds = current.PreviousIds
+	}
+
+	if commitMessage == "" {
+		commitMessage = fmt.Sprintf("Restore recipe %s to version %s", id, hash)
+	} else {
+		commitMessage = fmt.Sprintf("%s\n\nRestored from %s", commitMessage, hash)
+	}
+
+	if err := db.addOrUpdateRecipe(recipe, commitMessage, authorName); err != nil {
+		return recipe, err
+	}
+
+	return recipe, nil
+}
+
+// DiffRecipeVersions compares the recipe at two commits. An empty to means the latest version,
+// and an empty from means the version before to.
+func (db *RecipeDatabase) DiffRecipeVersions(id string, from, to string) (diff models.RecipeDiff, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if from == "" || to == "" {
+		history, err := db.getRecipeHistory(id)
+		if err != nil {
+			return diff, err
+		}
+		if len(history) == 0 {
+			return diff, fmt.Errorf("%w: %s has no history", ErrVersionNotFound, id)
+		}
+
+		if to == "" {
+			to = history[0].Hash
+		}
+		if from == "" {
+			found := false
+			for i, commit := range history {
+				if commit.Hash == to {
+					found = true
+					if i+1 < len(history) {
+						from = history[i+1].Hash
+					}
+				}
+			}
+			if !found {
+				return diff, fmt.Errorf("%w: %s is not in the history of %s", ErrVersionNotFound, to, id)
+			}
+		}
+	}
+
+	// Without an earlier version, everything in the recipe was added
+	var fromRecipe models.Recipe
+	if from != "" {
+		fromRecipe, err = db.getRecipeVersion(id, from)
+		if err != nil {
+			return diff, err
+		}
+	}
+
+	toRecipe, err := db.getRecipeVersion(id, to)
+	if err != nil {
+		return diff, err
+	}
+
+	diff = recipediff.Diff(fromRecipe, toRecipe)
+	diff.From = from
+	diff.To = to
+
+	return diff, nil
+}
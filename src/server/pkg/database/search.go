@@ -0,0 +1,43 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/search"
+)
+
+// SearchRecipes searches the recipes at HEAD, using the search index kept with the recipe index
+func (db *RecipeDatabase) SearchRecipes(query search.Query) (models.RecipeSearchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	result := db.index.search.Search(query, time.Now())
+	// The index shares its recipes with every search, so hand out fresh copies
+	for i, hit := range result.Hits {
+		recipe, err := db.getRecipe(hit.Recipe.Id)
+		if err != nil {
+			return result, err
+		}
+		result.Hits[i].Recipe = recipe
+	}
+	return result, nil
+}
+
+// SearchRecipes indexes the recipes for every search, as they are read from the files every time
+func (s *DirectoryStore) SearchRecipes(query search.Query) (models.RecipeSearchResult, error) {
+	recipes, err := s.GetRecipes(true)
+	if err != nil {
+		return models.RecipeSearchResult{}, err
+	}
+
+	index := search.NewIndex()
+	for _, recipe := range recipes {
+		rlogs, err := s.GetRecipeLogs(recipe.Id)
+		if err != nil {
+			return models.RecipeSearchResult{}, err
+		}
+		index.Put(search.NewDocument(recipe, rlogs))
+	}
+	return index.Search(query, time.Now()), nil
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jonasmh/recipetracker/pkg/config"
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/search"
+)
+
+// Store is where recipes and their logs are kept. RecipeDatabase stores them in a git repository,
+// on disk or in memory, and DirectoryStore stores plain files without history.
+type Store interface {
+	RecipeStore
+	LogStore
+	PhotoStore
+	AttachmentStore
+	HistoryStore
+	ExperimentStore
+	SyncStore
+
+	// Close releases the storage, after which the store must not be used
+	Close() error
+}
+
+type RecipeStore interface {
+	GetRecipes(includeArchived bool) ([]models.Recipe, error)
+	// ListRecipes returns the recipes with what the recipe list can be sorted by
+	ListRecipes(includeArchived bool) ([]models.RecipeListing, error)
+	GetRecipe(id string) (models.Recipe, error)
+	CreateRecipe(recipe models.Recipe, commitMessage, authorName string) (models.Recipe, error)
+	// UpdateRecipe replaces an existing recipe, keeping its previous ids, fork parent and archived state
+	UpdateRecipe(recipe models.Recipe, commitMessage, authorName string) (models.Recipe, error)
+	DeleteRecipe(id string, commitMessage, authorName string) error
+	RenameRecipe(oldId, newId string, commitMessage, authorName string) error
+	SetRecipeArchived(id string, archived bool, commitMessage, authorName string) error
+	ForkRecipe(parentId, newId, title string, commitMessage, authorName string) (models.Recipe, error)
+	GetRecipeFamily(id string) (models.RecipeFamily, error)
+	SearchRecipes(query search.Query) (models.RecipeSearchResult, error)
+}
+
+type LogStore interface {
+	GetRecipeLogs(recipeId string) ([]models.RecipeLog, error)
+	GetRecipeLog(recipeId string, logId string) (*models.RecipeLog, error)
+	AddRecipeLog(rlog models.RecipeLog, commitMessage, authorName string) (models.RecipeLog, error)
+	UpdateRecipeLog(rlog models.RecipeLog, lastCommit string, commitMessage, authorName string) error
+	DeleteRecipeLog(recipeId string, logId string, commitMessage, authorName string) error
+	PromoteRecipeLog(recipeId string, logId string, commitMessage, authorName string) (models.Recipe, error)
+	GetRecipeDrift(recipeId string) (models.RecipeDrift, error)
+}
+
+// PhotoStore keeps photos of how a recipe turned out, next to the log
+type PhotoStore interface {
+	GetLogPhotos(recipeId, logId string) ([]models.LogPhoto, error)
+	GetLogPhoto(recipeId, logId, photoId string) (models.LogPhoto, []byte, error)
+	AddLogPhoto(recipeId, logId string, data []byte, commitMessage, authorName string) (models.LogPhoto, error)
+	DeleteLogPhoto(recipeId, logId, photoId string, commitMessage, authorName string) error
+}
+
+// AttachmentStore keeps files attached to a recipe, named by the hash of their content
+type AttachmentStore interface {
+	GetAttachments(recipeId string) ([]models.Attachment, error)
+	GetAttachment(recipeId, id string) (models.Attachment, []byte, error)
+	// AddAttachment stores the file, or returns the existing attachment if the recipe already has it
+	AddAttachment(recipeId string, data []byte, commitMessage, authorName string) (models.Attachment, error)
+	DeleteAttachment(recipeId, id string, commitMessage, authorName string) error
+}
+
+// HistoryStore gives access to earlier versions of recipes. Stores without history return ErrNoHistory.
+type HistoryStore interface {
+	GetRecipeHistory(id string) ([]models.Commit, error)
+	GetRecipeVersion(id string, hash string) (models.Recipe, error)
+	RestoreRecipeVersion(id string, hash string, commitMessage, authorName string) (models.Recipe, error)
+	DiffRecipeVersions(id string, from, to string) (models.RecipeDiff, error)
+}
+
+// ExperimentStore keeps variations of a recipe apart from the recipe until they are merged.
+// Stores without history return ErrNoHistory.
+type ExperimentStore interface {
+	GetExperiments(recipeId string) ([]models.Experiment, error)
+	GetExperiment(recipeId, id string) (models.Experiment, error)
+	CreateExperiment(recipeId, id string) (models.Experiment, error)
+	GetExperimentRecipe(recipeId, id string) (models.Recipe, error)
+	UpdateExperimentRecipe(id string, recipe models.Recipe, commitMessage, authorName string) error
+	GetExperimentLogs(recipeId, id string) ([]models.RecipeLog, error)
+	AddExperimentLog(id string, rlog models.RecipeLog, commitMessage, authorName string) (models.RecipeLog, error)
+	MergeExperiment(recipeId, id string, commitMessage, authorName string) error
+	DiscardExperiment(recipeId, id string) error
+}
+
+// SyncStore exchanges changes with a remote, and reloads anything cached from storage
+type SyncStore interface {
+	Push() error
+	Pull() error
+	Status() (models.SyncStatus, error)
+	RebuildIndex() error
+
+	GetMergeConflicts() ([]models.MergeConflict, error)
+	GetMergeConflict(id string) (models.MergeConflict, error)
+	ResolveMergeConflict(id string, resolved json.RawMessage, commitMessage, authorName string) error
+}
+
+var (
+	_ Store = (*RecipeDatabase)(nil)
+	_ Store = (*DirectoryStore)(nil)
+)
+
+// Open creates the store selected by cfg.Storage.Backend
+func Open(cfg *config.Config) (Store, error) {
+	switch cfg.Storage.Backend {
+	case "", config.StorageGit:
+		db, err := New(cfg.Git)
+		return withAttachmentStore(db, err, cfg.Attachments)
+	case config.StorageMemory:
+		db, err := NewInMemory(cfg.Git)
+		return withAttachmentStore(db, err, cfg.Attachments)
+	case config.StorageDirectory:
+		return NewDirectoryStore(cfg.Storage.Directory)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// withAttachmentStore keeps large attachments of the database in the attachment store, if one is configured
+func withAttachmentStore(db *RecipeDatabase, err error, cfg config.AttachmentsConfig) (Store, error) {
+	if err != nil {
+		return nil, err
+	}
+	if db.blobs, err = newBlobStore(cfg); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
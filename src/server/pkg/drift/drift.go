@@ -0,0 +1,166 @@
+// Package drift compares the ingredients actually used in recipe logs with the recipe, to show
+// how a recipe is cooked in practice.
+package drift
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/units"
+)
+
+// Sample is a log together with the version of the recipe that was current when it was written
+type Sample struct {
+	Log    models.RecipeLog
+	Recipe models.Recipe
+}
+
+// Analyze compares every sample. Ingredients are reported in the order of the current recipe,
+// followed by ingredients only found in earlier versions.
+func Analyze(current models.Recipe, samples []Sample) models.RecipeDrift {
+	result := models.RecipeDrift{
+		RecipeId:    current.Id,
+		Ingredients: make([]models.IngredientDrift, 0),
+		Extras:      make([]models.IngredientFrequency, 0),
+		Skipped:     make([]models.IngredientFrequency, 0),
+	}
+
+	var order []string
+	drifts := make(map[string]*models.IngredientDrift)
+	deviations := make(map[string][]float64)
+	addIngredient := func(ingredient models.RecipeIngredient) string {
+		key := ingredientKey(ingredient)
+		if _, ok := drifts[key]; !ok {
+			drifts[key] = &models.IngredientDrift{Name: ingredient.Name}
+			order = append(order, key)
+		}
+		return key
+	}
+	for _, ingredient := range current.Ingredients {
+		addIngredient(ingredient)
+	}
+
+	extras := newCounter()
+	skipped := newCounter()
+	for _, sample := range samples {
+		if len(sample.Log.ActualIngredients) == 0 {
+			continue
+		}
+		result.Logs++
+
+		actual := make(map[string]models.RecipeIngredient, len(sample.Log.ActualIngredients))
+		for _, ingredient := range sample.Log.ActualIngredients {
+			actual[ingredientKey(ingredient)] = ingredient
+		}
+
+		written := make(map[string]bool, len(sample.Recipe.Ingredients))
+		for _, ingredient := range sample.Recipe.Ingredients {
+			key := addIngredient(ingredient)
+			written[key] = true
+
+			used, ok := actual[key]
+			if !ok {
+				skipped.add(ingredient.Name)
+				continue
+			}
+			drifts[key].Used++
+			if deviation, ok := deviation(ingredient, used); ok {
+				drifts[key].Compared++
+				deviations[key] = append(deviations[key], deviation)
+			}
+		}
+
+		for key, ingredient := range actual {
+			if !written[key] {
+				extras.add(ingredient.Name)
+			}
+		}
+	}
+
+	for _, key := range order {
+		drift := drifts[key]
+		if values := deviations[key]; len(values) > 0 {
+			drift.MinDeviation, drift.MaxDeviation = math.Inf(1), math.Inf(-1)
+			sum := 0.0
+			for _, value := range values {
+				sum += value
+				drift.MinDeviation = math.Min(drift.MinDeviation, value)
+				drift.MaxDeviation = math.Max(drift.MaxDeviation, value)
+			}
+			drift.AverageDeviation = round(sum / float64(len(values)))
+			drift.MinDeviation = round(drift.MinDeviation)
+			drift.MaxDeviation = round(drift.MaxDeviation)
+		}
+		result.Ingredients = append(result.Ingredients, *drift)
+	}
+	result.Extras = extras.frequencies(result.Logs)
+	result.Skipped = skipped.frequencies(result.Logs)
+
+	return result
+}
+
+// round removes the noise of float32 quantities, keeping a hundredth of a percent
+func round(deviation float64) float64 {
+	return math.Round(deviation*10000) / 10000
+}
+
+func ingredientKey(ingredient models.RecipeIngredient) string {
+	return strings.ToLower(strings.TrimSpace(ingredient.Name))
+}
+
+// deviation returns how much more of the ingredient was used than written, relative to the
+// written quantity. Quantities are compared in the base unit of their kind when possible.
+func deviation(written, used models.RecipeIngredient) (float64, bool) {
+	writtenBase, writtenKind, okWritten := units.ToBase(written)
+	usedBase, usedKind, okUsed := units.ToBase(used)
+	switch {
+	case okWritten && okUsed:
+		if writtenKind != usedKind || writtenBase <= 0 {
+			return 0, false
+		}
+		return (usedBase - writtenBase) / writtenBase, true
+	case units.Normalize(written.Unit) == units.Normalize(used.Unit) && written.Quantity > 0:
+		// Counts and unknown units, e.g. "2 eggs"
+		return float64(used.Quantity-written.Quantity) / float64(written.Quantity), true
+	}
+	return 0, false
+}
+
+// counter counts ingredients by name, keeping the first spelling seen
+type counter struct {
+	names  map[string]string
+	counts map[string]int
+}
+
+func newCounter() *counter {
+	return &counter{names: make(map[string]string), counts: make(map[string]int)}
+}
+
+func (c *counter) add(name string) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if _, ok := c.names[key]; !ok {
+		c.names[key] = name
+	}
+	c.counts[key]++
+}
+
+// frequencies returns the counts, most frequent first
+func (c *counter) frequencies(total int) []models.IngredientFrequency {
+	result := make([]models.IngredientFrequency, 0, len(c.counts))
+	for key, count := range c.counts {
+		result = append(result, models.IngredientFrequency{
+			Name:      c.names[key],
+			Count:     count,
+			Frequency: float64(count) / float64(total),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name)
+	})
+	return result
+}
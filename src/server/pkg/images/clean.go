@@ -0,0 +1,293 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// Clean removes metadata such as EXIF, which can include where and when a photo was taken, and
+// scales the image down to fit within maxDimension x maxDimension pixels. Images that are small
+// enough only have their metadata removed, so they are not recompressed. JPEG photos turned with
+// an EXIF orientation are rotated upright, as the orientation is removed with the rest of EXIF.
+//
+// Images that are scaled down are stored as JPEG, or as PNG if they have transparency, except
+// JPEG and PNG images which keep their format. Animated GIFs that are too large lose their animation.
+// Images with more than MaxPixels pixels, counting every frame of an animated GIF, are rejected
+// before they are decoded.
+func Clean(data []byte, maxDimension int) ([]byte, error) {
+	contentType, _, err := Sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	tooLarge := config.Width > maxDimension || config.Height > maxDimension
+
+	switch contentType {
+	case "image/jpeg":
+		stripped, orientation, err := stripJpeg(data)
+		if err != nil {
+			return nil, err
+		}
+		if !tooLarge && orientation == 1 {
+			return stripped, nil
+		}
+		src, err := jpeg.Decode(bytes.NewReader(stripped))
+		if err != nil {
+			return nil, err
+		}
+		// Fitting within a square first leaves orient a smaller image to copy
+		return encodeJpeg(orient(Fit(src, maxDimension), orientation))
+	case "image/png":
+		if !tooLarge {
+			return stripPng(data)
+		}
+	case "image/gif":
+		if !tooLarge {
+			// The encoder writes no comments or application data, and the frames keep their palettes
+			all, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := gif.EncodeAll(&buf, all); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+	case "image/webp":
+		if !tooLarge {
+			return stripWebp(data)
+		}
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	fitted := Fit(src, maxDimension)
+	if contentType != "image/png" && isOpaque(fitted) {
+		return encodeJpeg(fitted)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, fitted); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJpeg(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// keptJpegSegments are the application segments kept in JPEG images: JFIF, ICC profiles and
+// Adobe color transforms
+var keptJpegSegments = map[byte]bool{0xe0: true, 0xe2: true, 0xee: true}
+
+// stripJpeg removes all application segments except JFIF, ICC profiles and Adobe color transforms,
+// and comments. It returns the orientation from EXIF, 1 if there is none.
+func stripJpeg(data []byte) ([]byte, int, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	orientation := 1
+
+	i := 2
+	for {
+		if i+4 > len(data) || data[i] != 0xff {
+			return nil, 0, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			// The image data follows the start of scan, and is copied as is
+			return append(out, data[i:]...), orientation, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errMalformed
+		}
+		segment := data[i:end]
+		switch {
+		case marker == 0xe1:
+			if payload := segment[4:]; bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				orientation = exifOrientation(payload[6:])
+			}
+		case marker == 0xfe || (marker >= 0xe0 && marker <= 0xef && !keptJpegSegments[marker]):
+		default:
+			out = append(out, segment...)
+		}
+		i = end
+	}
+}
+
+// exifOrientation reads the orientation tag of the first image in the TIFF structure of EXIF
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Orientation is a single SHORT, stored in the first bytes of the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if value := int(order.Uint16(tiff[entry+8:])); value >= 1 && value <= 8 {
+				return value
+			}
+		}
+	}
+	return 1
+}
+
+// orient turns the image as given by an EXIF orientation, so that it is upright
+func orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// pngMetadata are the chunks removed from PNG images
+var pngMetadata = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPng(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, signature...)
+
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errMalformed
+		}
+		chunkType := string(data[i+4 : i+8])
+		if crc32.ChecksumIEEE(data[i+4:end-4]) != binary.BigEndian.Uint32(data[end-4:]) {
+			return nil, errMalformed
+		}
+		if !pngMetadata[chunkType] {
+			out = append(out, data[i:end]...)
+		}
+		if chunkType == "IEND" {
+			break
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebp removes the EXIF and XMP chunks of an extended WebP image
+func stripWebp(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourcc := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		// Chunks are padded to an even size, though some encoders leave out the padding of the last
+		end := min(i+8+size+size%2, len(data))
+		if i+8+size > len(data) {
+			return nil, errMalformed
+		}
+		switch fourcc {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				// Clear the EXIF and XMP flags
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
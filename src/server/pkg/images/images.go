@@ -0,0 +1,190 @@
+// Package images checks uploaded images and makes thumbnails of them
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	// Decoders for image.Decode
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// extensions maps the supported content types to the file extension they are stored with
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// MaxPixels is the most pixels an image may have. Decoding takes at least 4 bytes per pixel,
+// and a small file can hold a huge image that compresses well.
+const MaxPixels = 50_000_000
+
+var ErrUnsupported = errors.New("unsupported image type, must be JPEG, PNG, GIF or WebP")
+
+// Sniff detects the content type of the image from its contents, and checks that it can be
+// decoded and has no more than MaxPixels pixels
+func Sniff(data []byte) (contentType, extension string, err error) {
+	contentType = http.DetectContentType(data)
+	extension, ok := extensions[contentType]
+	if !ok {
+		return "", "", ErrUnsupported
+	}
+	if _, err := decodeConfig(data); err != nil {
+		return "", "", fmt.Errorf("invalid %s image: %w", contentType, err)
+	}
+	return contentType, extension, nil
+}
+
+// decodeConfig reads the dimensions of the image, rejecting images with more than MaxPixels
+// pixels before anything decodes them. The pixels of all the frames of an animated GIF count.
+func decodeConfig(data []byte) (image.Config, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return config, err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return config, fmt.Errorf("%dx%d pixels is more than the maximum of %d megapixels", config.Width, config.Height, MaxPixels/1_000_000)
+	}
+	if format == "gif" {
+		frames, pixels, err := gifPixels(data)
+		if err != nil {
+			return config, err
+		}
+		if pixels > MaxPixels {
+			return config, fmt.Errorf("%d frames of %d pixels in total is more than the maximum of %d megapixels", frames, pixels, MaxPixels/1_000_000)
+		}
+	}
+	return config, nil
+}
+
+// gifPixels counts the frames of a GIF and the pixels in all of them, reading only the block
+// structure so nothing is decompressed
+func gifPixels(data []byte) (frames int, pixels int64, err error) {
+	// The header is followed by the logical screen descriptor, and the global color table if its flag is set
+	const headerSize = 13
+	if len(data) < headerSize {
+		return 0, 0, errMalformed
+	}
+	i := headerSize
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks skips data sub-blocks, each preceded by its size, up to the empty block ending them
+	skipSubBlocks := func() error {
+		for {
+			if i >= len(data) {
+				return errMalformed
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	for {
+		if i >= len(data) {
+			return frames, pixels, nil // Some encoders leave out the trailer
+		}
+		switch data[i] {
+		case 0x21: // Extension, with a label
+			i += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2c: // Image descriptor, with the frame's position and size
+			if i+10 > len(data) {
+				return 0, 0, errMalformed
+			}
+			width := int64(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int64(binary.LittleEndian.Uint16(data[i+7:]))
+			frames++
+			pixels += width * height
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// The LZW minimum code size precedes the image data
+			i++
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x3b: // Trailer
+			return frames, pixels, nil
+		default:
+			return 0, 0, errMalformed
+		}
+	}
+}
+
+// IsImage reports whether the content is one of the supported image types, without checking it decodes
+func IsImage(data []byte) bool {
+	_, ok := extensions[http.DetectContentType(data)]
+	return ok
+}
+
+// ContentType returns the content type of images stored with the extension, or "" if it is not an image
+func ContentType(extension string) string {
+	for contentType, ext := range extensions {
+		if ext == extension {
+			return contentType
+		}
+	}
+	return ""
+}
+
+// Thumbnail scales the image to fit within size x size pixels, and encodes it as JPEG.
+// Smaller images are not scaled up.
+func Thumbnail(data []byte, size int) ([]byte, error) {
+	if _, err := decodeConfig(data); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// JPEG has no transparency, so transparent parts are made white rather than black
+	fitted := Fit(src, size)
+	flat := image.NewRGBA(image.Rect(0, 0, fitted.Bounds().Dx(), fitted.Bounds().Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), fitted, fitted.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fit scales the image down to fit within size x size pixels, keeping its aspect ratio
+func Fit(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return src
+	}
+	if width > height {
+		width, height = size, max(1, height*size/width)
+	} else {
+		width, height = max(1, width*size/height), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,13 @@
+package models
+
+// Attachment is a file kept with a recipe, like a photo of the finished dish or a scan of the
+// original recipe card
+type Attachment struct {
+	// Id is the SHA-256 hash of the content, so the same file is only stored once per recipe
+	Id          string `json:"id"`
+	RecipeId    string `json:"recipeId"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// External is set when the content is kept in the attachment store, outside of the repository
+	External bool `json:"external,omitempty"`
+}
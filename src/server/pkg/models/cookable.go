@@ -0,0 +1,15 @@
+package models
+
+// CookableRecipe is how much of a recipe can be made with the ingredients at hand
+type CookableRecipe struct {
+	RecipeId string `json:"recipeId"`
+	Title    string `json:"title"`
+	// Coverage is the share of the ingredients that are at hand, from 0 to 1. An ingredient
+	// there is too little of counts for the part there is.
+	Coverage float64 `json:"coverage"`
+	// Have are the ingredients of the recipe that are at hand
+	Have []RecipeIngredient `json:"have"`
+	// Missing are the ingredients that are not at hand. When there is too little of an
+	// ingredient, its quantity is how much more is needed.
+	Missing []RecipeIngredient `json:"missing"`
+}
@@ -0,0 +1,47 @@
+package models
+
+type RecipeDiff struct {
+	From        string       `json:"from"`
+	To          string       `json:"to"`
+	Title       *TextChange  `json:"title,omitempty"`
+	Description *TextChange  `json:"description,omitempty"`
+	Yield       *YieldChange `json:"yield,omitempty"`
+
+	IngredientsAdded   []RecipeIngredient `json:"ingredientsAdded"`
+	IngredientsRemoved []RecipeIngredient `json:"ingredientsRemoved"`
+	IngredientsRenamed []IngredientChange `json:"ingredientsRenamed"`
+	// IngredientsChanged are ingredients whose quantity or unit changed
+	IngredientsChanged []IngredientChange `json:"ingredientsChanged"`
+
+	Steps []StepChange `json:"steps"`
+}
+
+type TextChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Lines is a line based diff of From and To
+	Lines []TextDiffLine `json:"lines"`
+}
+
+type TextDiffLine struct {
+	// Op is one of "equal", "insert" or "delete"
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type YieldChange struct {
+	From *RecipeYield `json:"from"`
+	To   *RecipeYield `json:"to"`
+}
+
+type IngredientChange struct {
+	From RecipeIngredient `json:"from"`
+	To   RecipeIngredient `json:"to"`
+}
+
+type StepChange struct {
+	Index int `json:"index"`
+	// From is nil when the step was added, To is nil when it was removed
+	From *RecipeStep `json:"from"`
+	To   *RecipeStep `json:"to"`
+}
@@ -0,0 +1,34 @@
+package models
+
+// RecipeDrift compares how a recipe is cooked, according to its logs, with how it is written
+type RecipeDrift struct {
+	RecipeId string `json:"recipeId"`
+	// Logs counts the logs with actual ingredients, which are the ones compared
+	Logs        int               `json:"logs"`
+	Ingredients []IngredientDrift `json:"ingredients"`
+	// Extras are ingredients used in logs but not in the recipe, most frequent first
+	Extras []IngredientFrequency `json:"extras"`
+	// Skipped are ingredients in the recipe but not used in logs, most frequent first
+	Skipped []IngredientFrequency `json:"skipped"`
+}
+
+// IngredientDrift is how the quantity of a recipe ingredient deviates in the logs that used it.
+// Deviations are relative to the recipe, e.g. 0.1 means 10% more than written.
+type IngredientDrift struct {
+	Name string `json:"name"`
+	// Used counts the logs that used the ingredient, and Compared those where the quantities could
+	// be compared. Quantities in units of different kinds, e.g. grams and pieces, can't be compared.
+	Used     int `json:"used"`
+	Compared int `json:"compared"`
+	// The deviations are zero when no quantities were compared
+	AverageDeviation float64 `json:"averageDeviation"`
+	MinDeviation     float64 `json:"minDeviation"`
+	MaxDeviation     float64 `json:"maxDeviation"`
+}
+
+type IngredientFrequency struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// Frequency is Count divided by the number of logs compared
+	Frequency float64 `json:"frequency"`
+}
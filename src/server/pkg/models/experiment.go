@@ -0,0 +1,14 @@
+package models
+
+// Experiment is a variation of a recipe, kept on its own branch until it is merged into the
+// recipe or discarded
+type Experiment struct {
+	Id       string `json:"id"`
+	RecipeId string `json:"recipeId"`
+	Branch   string `json:"branch"`
+	// BaseCommit is the commit on the main branch the experiment started from, or was last
+	// merged with
+	BaseCommit string `json:"baseCommit"`
+	// Commits are the commits made on the experiment since BaseCommit, newest first
+	Commits []Commit `json:"commits"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// RecipeListing is a recipe with what the recipe list can be sorted by besides its title
+type RecipeListing struct {
+	Recipe Recipe `json:"recipe"`
+	// LastModified is when the recipe was last changed, RFC 3339
+	LastModified string `json:"lastModified,omitempty"`
+	// LastCooked is when the recipe was last logged, empty when it never was
+	LastCooked string `json:"lastCooked,omitempty"`
+	// Rating is the average rating of the logs, 0 when none are rated
+	Rating float64 `json:"rating,omitempty"`
+}
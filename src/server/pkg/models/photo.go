@@ -0,0 +1,10 @@
+package models
+
+// LogPhoto is a photo attached to a recipe log
+type LogPhoto struct {
+	Id          string `json:"id"`
+	RecipeId    string `json:"recipeId"`
+	LogId       string `json:"logId"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
@@ -0,0 +1,28 @@
+package models
+
+// RatingStats summarizes the outcomes recorded in the logs of a recipe
+type RatingStats struct {
+	Logs int `json:"logs"`
+	// Rated counts the logs with a rating. The average, min and max are zero when none are rated.
+	Rated         int     `json:"rated"`
+	AverageRating float64 `json:"averageRating"`
+	MinRating     int     `json:"minRating"`
+	MaxRating     int     `json:"maxRating"`
+	// RatingCounts counts the logs with each rating, from 1 to 5
+	RatingCounts [5]int `json:"ratingCounts"`
+	// DinerAverages is the average rating given by each diner
+	DinerAverages     map[string]float64 `json:"dinerAverages"`
+	WouldMakeAgain    int                `json:"wouldMakeAgain"`
+	WouldNotMakeAgain int                `json:"wouldNotMakeAgain"`
+	// AverageCookTimeMinutes is zero when no cook times are recorded
+	AverageCookTimeMinutes float64 `json:"averageCookTimeMinutes"`
+	// Tags counts the outcome tags, most frequent first
+	Tags []TagCount `json:"tags"`
+	// LastCooked is when the newest log was created, in RFC3339
+	LastCooked string `json:"lastCooked,omitempty"`
+}
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
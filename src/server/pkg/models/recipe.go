@@ -5,12 +5,61 @@ type Recipe struct {
 	Title       string             `json:"title"`
 	Description string             `json:"description"`
 	Ingredients []RecipeIngredient `json:"ingredients"`
+	Steps       []RecipeStep       `json:"steps"`
+	// Tags group recipes for browsing and search, e.g. "vegetarian" or "weeknight"
+	Tags []string `json:"tags,omitempty"`
+	// Yield is how much the recipe makes, e.g. "4 servings" or "1 loaf"
+	Yield *RecipeYield `json:"yield,omitempty"`
+	// Archived recipes are hidden from the recipe list, but keep their logs and history
+	Archived bool `json:"archived,omitempty"`
+	// PreviousIds are the ids the recipe was stored under before being renamed
+	PreviousIds []string `json:"previousIds,omitempty"`
+	// ForkedFrom is the recipe this recipe started as a copy of
+	ForkedFrom *RecipeParent `json:"forkedFrom,omitempty"`
+}
+
+// RecipeParent is the version of a recipe a fork was created from
+type RecipeParent struct {
+	RecipeId string `json:"recipeId"`
+	// Commit is the commit the parent was at when forked, empty for stores without history
+	Commit string `json:"commit,omitempty"`
+}
+
+// RecipeFamily is a recipe and the recipes forked from it, recursively
+type RecipeFamily struct {
+	Id         string         `json:"id"`
+	Title      string         `json:"title"`
+	Archived   bool           `json:"archived,omitempty"`
+	ForkedFrom *RecipeParent  `json:"forkedFrom,omitempty"`
+	Forks      []RecipeFamily `json:"forks"`
+}
+
+type RecipeYield struct {
+	Amount float32 `json:"amount"`
+	Unit   string  `json:"unit"`
+}
+
+type RecipeStep struct {
+	Text string `json:"text"`
+	// DurationMinutes is how long the step takes, if it is timed
+	DurationMinutes *float32 `json:"durationMinutes,omitempty"`
+	// Temperature is the oven/pan temperature of the step, if any
+	Temperature *RecipeTemperature `json:"temperature,omitempty"`
+	// Ingredients are names of the RecipeIngredient entries used in this step
+	Ingredients []string `json:"ingredients,omitempty"`
+}
+
+type RecipeTemperature struct {
+	Value float32 `json:"value"`
+	Unit  string  `json:"unit"`
 }
 
 type RecipeIngredient struct {
 	Name     string  `json:"name"`
 	Quantity float32 `json:"quantity"`
 	Unit     string  `json:"unit"`
+	// Unscalable ingredients (e.g. "salt to taste") keep their quantity when scaling
+	Unscalable bool `json:"unscalable,omitempty"`
 }
 
 type RecipeLog struct {
@@ -18,7 +67,22 @@ type RecipeLog struct {
 	RecipeId          string             `json:"recipeId"`
 	Description       string             `json:"description"`
 	ActualIngredients []RecipeIngredient `json:"actualIngredients"`
-	Commit            *Commit            `json:"commit"`
+	// PromotedCommit is the recipe commit that took over the actual ingredients of the log
+	PromotedCommit string `json:"promotedCommit,omitempty"`
+	// Rating is how good the result was, from 1 to 5, or 0 when not rated
+	Rating int `json:"rating,omitempty"`
+	// DinerRatings are the ratings from 1 to 5 given by each diner, by name
+	DinerRatings map[string]int `json:"dinerRatings,omitempty"`
+	// WouldMakeAgain is nil when not answered
+	WouldMakeAgain *bool `json:"wouldMakeAgain,omitempty"`
+	// CookTimeMinutes is how long cooking actually took
+	CookTimeMinutes *float32 `json:"cookTimeMinutes,omitempty"`
+	// Tags describe the outcome, e.g. "too salty"
+	Tags []string `json:"tags,omitempty"`
+	// Commit is the commit that last modified the log
+	Commit *Commit `json:"commit"`
+	// CreatedCommit is the commit that created the log
+	CreatedCommit *Commit `json:"createdCommit"`
 }
 
 type CommitAuthor struct {
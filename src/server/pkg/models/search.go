@@ -0,0 +1,30 @@
+package models
+
+// RecipeSearchResult is the recipes matching a search, best match first
+type RecipeSearchResult struct {
+	Total  int               `json:"total"`
+	Hits   []RecipeSearchHit `json:"hits"`
+	Facets RecipeFacets      `json:"facets"`
+}
+
+type RecipeSearchHit struct {
+	Recipe Recipe `json:"recipe"`
+	// Score is how well the recipe matches the text searched for, 0 when there is no text
+	Score float64 `json:"score"`
+}
+
+// RecipeFacets count the matching recipes by tag, ingredient, average rating and when they were
+// last cooked, so a search can be narrowed down further
+type RecipeFacets struct {
+	Tags        []FacetCount `json:"tags"`
+	Ingredients []FacetCount `json:"ingredients"`
+	// Ratings are the average ratings rounded down, from "5" to "1", and "unrated"
+	Ratings []FacetCount `json:"ratings"`
+	// LastCooked is one of "week", "month", "year", "older" and "never"
+	LastCooked []FacetCount `json:"lastCooked"`
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import "encoding/json"
+
+type SyncStatus struct {
+	// HasRemote is false when no remote is configured, in which case the other fields are empty
+	HasRemote bool `json:"hasRemote"`
+	// AutoSync is true when the server pulls and pushes in the background
+	AutoSync bool   `json:"autoSync"`
+	Branch   string `json:"branch,omitempty"`
+	// Ahead and Behind count the commits that differ from the remote branch, as of the last fetch
+	Ahead  int `json:"ahead"`
+	Behind int `json:"behind"`
+	// LastSync is when a push or pull last succeeded, in RFC3339
+	LastSync    string `json:"lastSync,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+	LastErrorAt string `json:"lastErrorAt,omitempty"`
+	// NextAttempt is when the background sync will retry after an error
+	NextAttempt string `json:"nextAttempt,omitempty"`
+	// Conflicts counts the unresolved merge conflicts
+	Conflicts int `json:"conflicts"`
+}
+
+// MergeConflict is a file that was changed differently on both sides when merging with the remote.
+// The merge keeps Merged in the repository until the conflict is resolved.
+type MergeConflict struct {
+	Id       string `json:"id"`
+	Path     string `json:"path"`
+	RecipeId string `json:"recipeId"`
+	LogId    string `json:"logId,omitempty"`
+	// Fields lists the conflicting fields, e.g. "title" or "ingredients[flour].quantity".
+	// It is empty when the whole file conflicts, e.g. when one side deleted it.
+	Fields []string `json:"fields"`
+	// Base, Ours, Theirs and Merged are the file contents, null where the file does not exist
+	Base   json.RawMessage `json:"base"`
+	Ours   json.RawMessage `json:"ours"`
+	Theirs json.RawMessage `json:"theirs"`
+	Merged json.RawMessage `json:"merged"`
+	// OursCommit and TheirsCommit are the local and remote commits that were merged
+	OursCommit   string `json:"oursCommit"`
+	TheirsCommit string `json:"theirsCommit"`
+}
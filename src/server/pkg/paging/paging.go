@@ -0,0 +1,143 @@
+// Package paging sorts lists and splits them into pages, with cursors marking where the next
+// page starts, so pages don't skip or repeat items when the list changes in between
+package paging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort is the key a list is sorted by, and its direction. It is written as the key, with a
+// leading "-" for descending order, e.g. "-rating".
+type Sort struct {
+	Key        string
+	Descending bool
+}
+
+// ParseSort reads a sort, which must be by one of the keys. An empty value is the default sort.
+func ParseSort(value, defaultValue string, keys ...string) (Sort, error) {
+	if value == "" {
+		value = defaultValue
+	}
+	s := Sort{Key: strings.TrimPrefix(value, "-"), Descending: strings.HasPrefix(value, "-")}
+	for _, key := range keys {
+		if s.Key == key {
+			return s, nil
+		}
+	}
+	return s, fmt.Errorf("sort must be one of %s, with a leading - for descending order", strings.Join(keys, ", "))
+}
+
+func (s Sort) String() string {
+	if s.Descending {
+		return "-" + s.Key
+	}
+	return s.Key
+}
+
+// Entry is an item of a list as it is sorted: its id, and its value of the sort key formatted
+// so values compare as strings. Items without a value have an empty key, and come last.
+type Entry struct {
+	Id  string
+	Key string
+}
+
+// less orders the entries by key, then by id so items with the same key keep their order between pages
+func (s Sort) less(a, b Entry) bool {
+	if (a.Key == "") != (b.Key == "") {
+		return b.Key == ""
+	}
+	if a.Key != b.Key {
+		return (a.Key < b.Key) != s.Descending
+	}
+	return a.Id < b.Id
+}
+
+// Cursor is the last entry of a page, in the sort the page was listed in
+type Cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k,omitempty"`
+	Id   string `json:"i"`
+}
+
+// String encodes the cursor for use in a URL
+func (c Cursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes a cursor, which must be from a page in the same sort
+func ParseCursor(value string, s Sort) (*Cursor, error) {
+	var c Cursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil || c.Id == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	if c.Sort != s.String() {
+		return nil, errors.New("cursor is from a list sorted by " + c.Sort)
+	}
+	return &c, nil
+}
+
+// Page sorts the items and returns the ones after the cursor, at most limit of them unless limit
+// is 0. The returned cursor marks where the next page starts, and is nil on the last page.
+func Page[T any](items []T, entry func(T) Entry, s Sort, after *Cursor, limit int) ([]T, *Cursor) {
+	entries := make([]Entry, len(items))
+	order := make([]int, len(items))
+	for i, item := range items {
+		entries[i] = entry(item)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return s.less(entries[order[i]], entries[order[j]]) })
+
+	start := 0
+	if after != nil {
+		last := Entry{Id: after.Id, Key: after.Key}
+		start = sort.Search(len(order), func(i int) bool { return s.less(last, entries[order[i]]) })
+	}
+	end := len(order)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	page := make([]T, 0, end-start)
+	for _, i := range order[start:end] {
+		page = append(page, items[i])
+	}
+	if end == len(order) {
+		return page, nil
+	}
+	last := entries[order[end-1]]
+	return page, &Cursor{Sort: s.String(), Key: last.Key, Id: last.Id}
+}
+
+// TimeKey is the key of an RFC 3339 time, or an empty key if it is empty or invalid
+func TimeKey(value string) string {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// NumberKey is the key of a number that is not negative, or an empty key for 0
+func NumberKey(value float64) string {
+	if value <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%020s", strconv.FormatFloat(value, 'f', 6, 64))
+}
+
+// TextKey is the key of text, compared without case
+func TextKey(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
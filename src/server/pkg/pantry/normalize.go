@@ -0,0 +1,137 @@
+package pantry
+
+import (
+	"strings"
+	"unicode"
+)
+
+// synonyms maps names of the same ingredient to one of them. Keys and values are singular,
+// as names are singularized before they are looked up.
+var synonyms = map[string]string{
+	"scallion":               "spring onion",
+	"green onion":            "spring onion",
+	"salad onion":            "spring onion",
+	"cilantro":               "coriander",
+	"coriander leaf":         "coriander",
+	"eggplant":               "aubergine",
+	"zucchini":               "courgette",
+	"capsicum":               "bell pepper",
+	"sweet pepper":           "bell pepper",
+	"garbanzo":               "chickpea",
+	"garbanzo bean":          "chickpea",
+	"arugula":                "rocket",
+	"shrimp":                 "prawn",
+	"ground beef":            "minced beef",
+	"beef mince":             "minced beef",
+	"ground pork":            "minced pork",
+	"pork mince":             "minced pork",
+	"heavy cream":            "double cream",
+	"whipping cream":         "double cream",
+	"powdered sugar":         "icing sugar",
+	"confectioners sugar":    "icing sugar",
+	"superfine sugar":        "caster sugar",
+	"cornstarch":             "cornflour",
+	"corn starch":            "cornflour",
+	"baking soda":            "bicarbonate of soda",
+	"bicarb":                 "bicarbonate of soda",
+	"all purpose flour":      "plain flour",
+	"all-purpose flour":      "plain flour",
+	"self rising flour":      "self-raising flour",
+	"self-rising flour":      "self-raising flour",
+	"beetroot":               "beet",
+	"rutabaga":               "swede",
+	"snow pea":               "mangetout",
+	"fava bean":              "broad bean",
+	"rapeseed oil":           "canola oil",
+	"extra virgin olive oil": "olive oil",
+}
+
+// uncountable are words ending in s that are not plurals
+var uncountable = map[string]bool{
+	"asparagus": true, "couscous": true, "hummus": true, "molasses": true, "citrus": true,
+	"octopus": true, "schnapps": true, "gras": true, "haggis": true,
+}
+
+// irregular plurals that the suffix rules get wrong
+var irregular = map[string]string{
+	"leaves":    "leaf",
+	"loaves":    "loaf",
+	"halves":    "half",
+	"knives":    "knife",
+	"calves":    "calf",
+	"geese":     "goose",
+	"mice":      "mouse",
+	"teeth":     "tooth",
+	"feet":      "foot",
+	"anchovies": "anchovy",
+	"cookies":   "cookie",
+	"pies":      "pie",
+	"brownies":  "brownie",
+}
+
+// modifiers describe how an ingredient is prepared, or which variety of it, without making it
+// another ingredient. Words like "peanut" in "peanut butter" or "coconut" in "coconut milk" do,
+// and are not listed, nor are colours, as in "white chocolate" or "red pepper".
+var modifiers = map[string]bool{
+	"fresh": true, "dried": true, "frozen": true, "raw": true, "ripe": true, "organic": true,
+	"large": true, "medium": true, "small": true, "big": true, "whole": true, "extra": true,
+	"chopped": true, "diced": true, "sliced": true, "grated": true, "crushed": true,
+	"peeled": true, "halved": true, "finely": true, "roughly": true, "thinly": true, "freshly": true,
+}
+
+// Normalize turns an ingredient name into the form it is compared by: lowercase, without
+// punctuation, every word singular, and synonyms replaced by one name for the ingredient,
+// e.g. "Scallions" and "spring onion" both become "spring onion".
+func Normalize(name string) string {
+	fields := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+	for i, word := range fields {
+		fields[i] = singular(word)
+	}
+	// The longest synonym ending the name is replaced, so "fresh cilantro" becomes "fresh coriander"
+	for i := range fields {
+		if synonym, ok := synonyms[strings.Join(fields[i:], " ")]; ok {
+			return strings.Join(append(fields[:i:i], synonym), " ")
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// singular returns the singular of an English plural, or the word as is if it is not one
+func singular(word string) string {
+	if singular, ok := irregular[word]; ok {
+		return singular
+	}
+	if uncountable[word] || len(word) <= 3 || !strings.HasSuffix(word, "s") || strings.HasSuffix(word, "ss") {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies"):
+		// berries, cherries
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "oes"), strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"),
+		strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "sses"):
+		// tomatoes, peaches, radishes, boxes, glasses
+		return strings.TrimSuffix(word, "es")
+	case strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	default:
+		return strings.TrimSuffix(word, "s")
+	}
+}
+
+// core is a normalized name without its modifiers, or the name itself if it is only modifiers
+func core(name string) string {
+	fields := strings.Fields(name)
+	kept := fields[:0]
+	for _, word := range fields {
+		if !modifiers[word] {
+			kept = append(kept, word)
+		}
+	}
+	if len(kept) == 0 {
+		return name
+	}
+	return strings.Join(kept, " ")
+}
@@ -0,0 +1,114 @@
+// Package pantry finds the recipes that can be cooked with the ingredients at hand
+package pantry
+
+import (
+	"math"
+	"sort"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/units"
+)
+
+// Rank returns the recipes using any of the ingredients at hand, the ones with most of their
+// ingredients at hand first. Ingredients at hand without a quantity are taken to be enough, as
+// are quantities that can't be compared with the recipe's.
+func Rank(recipes []models.Recipe, have []models.RecipeIngredient) []models.CookableRecipe {
+	haveNames := make([]string, len(have))
+	for i, ingredient := range have {
+		haveNames[i] = Normalize(ingredient.Name)
+	}
+
+	cookable := make([]models.CookableRecipe, 0)
+	for _, recipe := range recipes {
+		if len(recipe.Ingredients) == 0 {
+			continue
+		}
+		result := models.CookableRecipe{
+			RecipeId: recipe.Id,
+			Title:    recipe.Title,
+			Have:     make([]models.RecipeIngredient, 0),
+			Missing:  make([]models.RecipeIngredient, 0),
+		}
+
+		covered := 0.0
+		for _, ingredient := range recipe.Ingredients {
+			i := find(Normalize(ingredient.Name), haveNames)
+			if i < 0 {
+				result.Missing = append(result.Missing, ingredient)
+				continue
+			}
+			share := enough(ingredient, have[i])
+			covered += share
+			if share >= 1 {
+				result.Have = append(result.Have, ingredient)
+			} else {
+				short := ingredient
+				short.Quantity = float32(units.Round(float64(ingredient.Quantity)*(1-share), ingredient.Unit))
+				result.Missing = append(result.Missing, short)
+			}
+		}
+		if covered == 0 {
+			continue
+		}
+
+		result.Coverage = math.Round(covered/float64(len(recipe.Ingredients))*1000) / 1000
+		cookable = append(cookable, result)
+	}
+
+	sort.SliceStable(cookable, func(i, j int) bool {
+		a, b := cookable[i], cookable[j]
+		if a.Coverage != b.Coverage {
+			return a.Coverage > b.Coverage
+		}
+		if len(a.Missing) != len(b.Missing) {
+			return len(a.Missing) < len(b.Missing)
+		}
+		return a.Title < b.Title
+	})
+	return cookable
+}
+
+// find returns the index of the ingredient at hand best matching the normalized name, or -1.
+// The same ingredient is the best match, then the same ingredient described differently, e.g.
+// "chopped onion" for "onion" or "fresh coriander" for "coriander". Other words are never ignored,
+// so "butter" is not taken for "peanut butter".
+func find(name string, haveNames []string) int {
+	best := -1
+	nameCore := core(name)
+	for i, haveName := range haveNames {
+		if haveName == name {
+			return i
+		}
+		if best < 0 && core(haveName) == nameCore {
+			best = i
+		}
+	}
+	return best
+}
+
+// SameIngredient reports whether two ingredient names name the same ingredient, differing only
+// in case, plurals, synonyms and modifiers, as "Fresh tomatoes" and "tomato" do
+func SameIngredient(a, b string) bool {
+	a, b = Normalize(a), Normalize(b)
+	return a == b || core(a) == core(b)
+}
+
+// enough returns how much of the needed quantity of the ingredient is at hand, from 0 to 1
+func enough(needed, have models.RecipeIngredient) float64 {
+	if have.Quantity <= 0 || needed.Quantity <= 0 || needed.Unscalable {
+		return 1
+	}
+
+	// Convert with the recipe's name for the ingredient, so volumes at hand get the same density
+	have.Name = needed.Name
+	neededBase, neededKind, okNeeded := units.ToBase(needed)
+	haveBase, haveKind, okHave := units.ToBase(have)
+	switch {
+	case okNeeded && okHave && neededKind == haveKind && neededBase > 0:
+		return min(1, haveBase/neededBase)
+	case units.Normalize(needed.Unit) == units.Normalize(have.Unit):
+		// Counts and unknown units, e.g. "2 eggs"
+		return min(1, float64(have.Quantity)/float64(needed.Quantity))
+	}
+	return 1
+}
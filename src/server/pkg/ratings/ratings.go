@@ -0,0 +1,91 @@
+// Package ratings summarizes the outcomes recorded in recipe logs
+package ratings
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// Stats summarizes the ratings, answers, cook times and tags of the logs
+func Stats(rlogs []models.RecipeLog) models.RatingStats {
+	stats := models.RatingStats{
+		Logs:          len(rlogs),
+		DinerAverages: make(map[string]float64),
+		Tags:          make([]models.TagCount, 0),
+	}
+
+	ratingSum := 0
+	dinerSums := make(map[string]int)
+	dinerCounts := make(map[string]int)
+	var cookTimeSum float64
+	cookTimes := 0
+	tagCounts := make(map[string]int)
+	tagNames := make(map[string]string)
+	var lastCooked time.Time
+
+	for _, rlog := range rlogs {
+		if rlog.Rating >= 1 && rlog.Rating <= 5 {
+			if stats.Rated == 0 || rlog.Rating < stats.MinRating {
+				stats.MinRating = rlog.Rating
+			}
+			if rlog.Rating > stats.MaxRating {
+				stats.MaxRating = rlog.Rating
+			}
+			stats.Rated++
+			ratingSum += rlog.Rating
+			stats.RatingCounts[rlog.Rating-1]++
+		}
+		for diner, rating := range rlog.DinerRatings {
+			dinerSums[diner] += rating
+			dinerCounts[diner]++
+		}
+		if rlog.WouldMakeAgain != nil {
+			if *rlog.WouldMakeAgain {
+				stats.WouldMakeAgain++
+			} else {
+				stats.WouldNotMakeAgain++
+			}
+		}
+		if rlog.CookTimeMinutes != nil {
+			cookTimeSum += float64(*rlog.CookTimeMinutes)
+			cookTimes++
+		}
+		for _, tag := range rlog.Tags {
+			key := strings.ToLower(strings.TrimSpace(tag))
+			if _, ok := tagNames[key]; !ok {
+				tagNames[key] = tag
+			}
+			tagCounts[key]++
+		}
+		if rlog.CreatedCommit != nil {
+			if created, err := time.Parse(time.RFC3339, rlog.CreatedCommit.Author.When); err == nil && created.After(lastCooked) {
+				lastCooked = created
+				stats.LastCooked = rlog.CreatedCommit.Author.When
+			}
+		}
+	}
+
+	if stats.Rated > 0 {
+		stats.AverageRating = float64(ratingSum) / float64(stats.Rated)
+	}
+	for diner, sum := range dinerSums {
+		stats.DinerAverages[diner] = float64(sum) / float64(dinerCounts[diner])
+	}
+	if cookTimes > 0 {
+		stats.AverageCookTimeMinutes = cookTimeSum / float64(cookTimes)
+	}
+	for key, count := range tagCounts {
+		stats.Tags = append(stats.Tags, models.TagCount{Tag: tagNames[key], Count: count})
+	}
+	sort.Slice(stats.Tags, func(i, j int) bool {
+		if stats.Tags[i].Count != stats.Tags[j].Count {
+			return stats.Tags[i].Count > stats.Tags[j].Count
+		}
+		return stats.Tags[i].Tag < stats.Tags[j].Tag
+	})
+
+	return stats
+}
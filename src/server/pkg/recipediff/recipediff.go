@@ -0,0 +1,181 @@
+package recipediff
+
+import (
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/pantry"
+	"github.com/jonasmh/recipetracker/pkg/units"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Diff compares two versions of a recipe field by field
+func Diff(from, to models.Recipe) models.RecipeDiff {
+	diff := models.RecipeDiff{
+		IngredientsAdded:   make([]models.RecipeIngredient, 0),
+		IngredientsRemoved: make([]models.RecipeIngredient, 0),
+		IngredientsRenamed: make([]models.IngredientChange, 0),
+		IngredientsChanged: make([]models.IngredientChange, 0),
+		Steps:              make([]models.StepChange, 0),
+	}
+
+	if from.Title != to.Title {
+		diff.Title = diffText(from.Title, to.Title)
+	}
+	if from.Description != to.Description {
+		diff.Description = diffText(from.Description, to.Description)
+	}
+	if !reflect.DeepEqual(from.Yield, to.Yield) {
+		diff.Yield = &models.YieldChange{From: from.Yield, To: to.Yield}
+	}
+
+	diffIngredients(&diff, from.Ingredients, to.Ingredients)
+	diffSteps(&diff, from.Steps, to.Steps)
+
+	return diff
+}
+
+func diffText(from, to string) *models.TextChange {
+	dmp := diffmatchpatch.New()
+	fromChars, toChars, lines := dmp.DiffLinesToChars(from, to)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(fromChars, toChars, false), lines)
+
+	change := &models.TextChange{From: from, To: to, Lines: make([]models.TextDiffLine, 0)}
+	for _, d := range diffs {
+		op := "equal"
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = "insert"
+		case diffmatchpatch.DiffDelete:
+			op = "delete"
+		}
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			change.Lines = append(change.Lines, models.TextDiffLine{Op: op, Text: strings.TrimSuffix(line, "\n")})
+		}
+	}
+	return change
+}
+
+func diffIngredients(diff *models.RecipeDiff, from, to []models.RecipeIngredient) {
+	toByName := make(map[string]models.RecipeIngredient)
+	for _, ingredient := range to {
+		toByName[ingredientKey(ingredient)] = ingredient
+	}
+	fromByName := make(map[string]models.RecipeIngredient)
+	for _, ingredient := range from {
+		fromByName[ingredientKey(ingredient)] = ingredient
+	}
+
+	var removed []models.RecipeIngredient
+	for _, before := range from {
+		after, ok := toByName[ingredientKey(before)]
+		if !ok {
+			removed = append(removed, before)
+			continue
+		}
+		if before.Quantity != after.Quantity || units.Normalize(before.Unit) != units.Normalize(after.Unit) {
+			diff.IngredientsChanged = append(diff.IngredientsChanged, models.IngredientChange{From: before, To: after})
+		}
+	}
+
+	var added []models.RecipeIngredient
+	for _, after := range to {
+		if _, ok := fromByName[ingredientKey(after)]; !ok {
+			added = append(added, after)
+		}
+	}
+
+	// An ingredient that disappeared while another with a similar name and the same amount
+	// appeared was most likely renamed
+	paired := make(map[int]bool)
+	for _, before := range removed {
+		match := -1
+		for i, after := range added {
+			if !paired[i] && similarNames(before.Name, after.Name) && sameAmount(before, after) {
+				match = i
+				break
+			}
+		}
+		if match < 0 {
+			diff.IngredientsRemoved = append(diff.IngredientsRemoved, before)
+			continue
+		}
+		paired[match] = true
+		diff.IngredientsRenamed = append(diff.IngredientsRenamed, models.IngredientChange{From: before, To: added[match]})
+	}
+	for i, after := range added {
+		if !paired[i] {
+			diff.IngredientsAdded = append(diff.IngredientsAdded, after)
+		}
+	}
+}
+
+func diffSteps(diff *models.RecipeDiff, from, to []models.RecipeStep) {
+	for i := 0; i < len(from) || i < len(to); i++ {
+		var before, after *models.RecipeStep
+		if i < len(from) {
+			before = &from[i]
+		}
+		if i < len(to) {
+			after = &to[i]
+		}
+		if before != nil && after != nil && reflect.DeepEqual(*before, *after) {
+			continue
+		}
+		diff.Steps = append(diff.Steps, models.StepChange{Index: i, From: before, To: after})
+	}
+}
+
+func ingredientKey(ingredient models.RecipeIngredient) string {
+	return strings.ToLower(strings.TrimSpace(ingredient.Name))
+}
+
+// sameAmount reports whether the two ingredients describe the same amount, even if written in different units
+func sameAmount(a, b models.RecipeIngredient) bool {
+	baseA, kindA, okA := units.ToBase(a)
+	baseB, kindB, okB := units.ToBase(b)
+	if okA && okB {
+		return kindA == kindB && math.Abs(baseA-baseB) <= 0.01*math.Max(math.Abs(baseA), math.Abs(baseB))
+	}
+	return a.Quantity == b.Quantity && units.Normalize(a.Unit) == units.Normalize(b.Unit)
+}
+
+// similarNames reports whether two ingredient names likely name the same ingredient: they differ
+// only in how it is prepared or described, as "tomatoes" and "chopped tomato", or are a few typos
+// apart, as in "tomatoe" and "tomato". Sharing a word is not enough, as "olive oil" and
+// "sesame oil" are different ingredients.
+func similarNames(a, b string) bool {
+	if pantry.SameIngredient(a, b) {
+		return true
+	}
+	a, b = strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b))
+	// Short names are left out, as one typo often turns them into another ingredient
+	return editDistance(a, b) <= min(len([]rune(a)), len([]rune(b)))/5
+}
+
+// editDistance counts the insertions, deletions and substitutions needed to turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
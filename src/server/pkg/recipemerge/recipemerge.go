@@ -0,0 +1,272 @@
+// Package recipemerge does three-way merges of recipes and logs changed on two sides.
+// Changes made on only one side are always kept. When both sides changed the same field
+// differently, ours is kept and the field is reported as a conflict.
+package recipemerge
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// MergeRecipe merges the changes from base to ours and from base to theirs. The returned
+// conflicts name the fields where both sides made different changes, e.g. "title" or
+// "ingredients[flour].quantity".
+func MergeRecipe(base, ours, theirs models.Recipe) (models.Recipe, []string) {
+	var conflicts []string
+
+	merged := ours
+	merged.Title = mergeText("title", base.Title, ours.Title, theirs.Title, &conflicts)
+	merged.Description = mergeText("description", base.Description, ours.Description, theirs.Description, &conflicts)
+	merged.Tags = mergeSet(base.Tags, ours.Tags, theirs.Tags)
+	merged.Yield = mergeValue("yield", base.Yield, ours.Yield, theirs.Yield, &conflicts)
+	merged.Archived = mergeValue("archived", base.Archived, ours.Archived, theirs.Archived, &conflicts)
+	merged.Ingredients = mergeIngredients("ingredients", base.Ingredients, ours.Ingredients, theirs.Ingredients, &conflicts)
+	merged.Steps = mergeSteps(base.Steps, ours.Steps, theirs.Steps, &conflicts)
+	merged.PreviousIds = union(ours.PreviousIds, theirs.PreviousIds)
+	merged.ForkedFrom = mergeValue("forkedFrom", base.ForkedFrom, ours.ForkedFrom, theirs.ForkedFrom, &conflicts)
+
+	return merged, conflicts
+}
+
+// MergeRecipeLog merges a log like MergeRecipe, with actual ingredients merged by name, diner
+// ratings by diner, and tags as a set
+func MergeRecipeLog(base, ours, theirs models.RecipeLog) (models.RecipeLog, []string) {
+	var conflicts []string
+
+	merged := ours
+	merged.Description = mergeText("description", base.Description, ours.Description, theirs.Description, &conflicts)
+	merged.ActualIngredients = mergeIngredients("actualIngredients", base.ActualIngredients, ours.ActualIngredients, theirs.ActualIngredients, &conflicts)
+	merged.PromotedCommit = mergeValue("promotedCommit", base.PromotedCommit, ours.PromotedCommit, theirs.PromotedCommit, &conflicts)
+	merged.Rating = mergeValue("rating", base.Rating, ours.Rating, theirs.Rating, &conflicts)
+	merged.DinerRatings = mergeRatings(base.DinerRatings, ours.DinerRatings, theirs.DinerRatings, &conflicts)
+	merged.WouldMakeAgain = mergeValue("wouldMakeAgain", base.WouldMakeAgain, ours.WouldMakeAgain, theirs.WouldMakeAgain, &conflicts)
+	merged.CookTimeMinutes = mergeValue("cookTimeMinutes", base.CookTimeMinutes, ours.CookTimeMinutes, theirs.CookTimeMinutes, &conflicts)
+	merged.Tags = mergeSet(base.Tags, ours.Tags, theirs.Tags)
+
+	return merged, conflicts
+}
+
+// mergeValue takes the side that changed, or ours with a conflict if both changed differently
+func mergeValue[T any](field string, base, ours, theirs T, conflicts *[]string) T {
+	switch {
+	case reflect.DeepEqual(ours, theirs), reflect.DeepEqual(base, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	}
+	*conflicts = append(*conflicts, field)
+	return ours
+}
+
+// mergeText applies the changes made on theirs to ours, as long as they don't touch the same text
+func mergeText(field string, base, ours, theirs string, conflicts *[]string) string {
+	if ours == theirs || base == theirs {
+		return ours
+	}
+	if base == ours {
+		return theirs
+	}
+
+	dmp := diffmatchpatch.New()
+	patches := dmp.PatchMake(base, theirs)
+	merged, applied := dmp.PatchApply(patches, ours)
+	for _, ok := range applied {
+		if !ok {
+			*conflicts = append(*conflicts, field)
+			return ours
+		}
+	}
+	return merged
+}
+
+func ingredientKey(ingredient models.RecipeIngredient) string {
+	return strings.ToLower(strings.TrimSpace(ingredient.Name))
+}
+
+// mergeIngredients merges ingredient lists by name, keeping the order of ours with ingredients
+// added on theirs at the end
+func mergeIngredients(field string, base, ours, theirs []models.RecipeIngredient, conflicts *[]string) []models.RecipeIngredient {
+	if equalSlices(ours, theirs) || equalSlices(base, theirs) {
+		return ours
+	}
+	if equalSlices(base, ours) {
+		return theirs
+	}
+
+	baseByName := byName(base)
+	oursByName := byName(ours)
+	theirsByName := byName(theirs)
+
+	merged := make([]models.RecipeIngredient, 0, len(ours))
+	for _, ingredient := range ours {
+		key := ingredientKey(ingredient)
+		itemField := field + "[" + key + "]"
+		baseIngredient, inBase := baseByName[key]
+		theirIngredient, inTheirs := theirsByName[key]
+
+		switch {
+		case inTheirs:
+			if !inBase {
+				// Added on both sides
+				baseIngredient = models.RecipeIngredient{Name: ingredient.Name}
+			}
+			merged = append(merged, mergeIngredient(itemField, baseIngredient, ingredient, theirIngredient, conflicts))
+		case !inBase:
+			merged = append(merged, ingredient) // Added on ours
+		case reflect.DeepEqual(baseIngredient, ingredient):
+			// Removed on theirs
+		default:
+			// Removed on theirs, but changed on ours. Keep it rather than lose the change.
+			*conflicts = append(*conflicts, itemField)
+			merged = append(merged, ingredient)
+		}
+	}
+
+	for _, ingredient := range theirs {
+		key := ingredientKey(ingredient)
+		if _, inOurs := oursByName[key]; inOurs {
+			continue
+		}
+		baseIngredient, inBase := baseByName[key]
+		switch {
+		case !inBase:
+			merged = append(merged, ingredient) // Added on theirs
+		case reflect.DeepEqual(baseIngredient, ingredient):
+			// Removed on ours
+		default:
+			*conflicts = append(*conflicts, field+"["+key+"]")
+			merged = append(merged, ingredient)
+		}
+	}
+
+	return merged
+}
+
+func mergeIngredient(field string, base, ours, theirs models.RecipeIngredient, conflicts *[]string) models.RecipeIngredient {
+	merged := ours
+	merged.Quantity = mergeValue(field+".quantity", base.Quantity, ours.Quantity, theirs.Quantity, conflicts)
+	merged.Unit = mergeValue(field+".unit", base.Unit, ours.Unit, theirs.Unit, conflicts)
+	merged.Unscalable = mergeValue(field+".unscalable", base.Unscalable, ours.Unscalable, theirs.Unscalable, conflicts)
+	return merged
+}
+
+func byName(ingredients []models.RecipeIngredient) map[string]models.RecipeIngredient {
+	m := make(map[string]models.RecipeIngredient, len(ingredients))
+	for _, ingredient := range ingredients {
+		m[ingredientKey(ingredient)] = ingredient
+	}
+	return m
+}
+
+// mergeSteps merges step by step when no steps were added or removed, as steps have no
+// identity beyond their position
+func mergeSteps(base, ours, theirs []models.RecipeStep, conflicts *[]string) []models.RecipeStep {
+	if equalSlices(ours, theirs) || equalSlices(base, theirs) {
+		return ours
+	}
+	if equalSlices(base, ours) {
+		return theirs
+	}
+	if len(base) != len(ours) || len(base) != len(theirs) {
+		*conflicts = append(*conflicts, "steps")
+		return ours
+	}
+
+	merged := make([]models.RecipeStep, len(ours))
+	for i := range ours {
+		merged[i] = mergeValue("steps["+strconv.Itoa(i)+"]", base[i], ours[i], theirs[i], conflicts)
+	}
+	return merged
+}
+
+// mergeRatings merges the ratings diner by diner, so diners rating on different sides are all kept
+func mergeRatings(base, ours, theirs map[string]int, conflicts *[]string) map[string]int {
+	if reflect.DeepEqual(ours, theirs) || reflect.DeepEqual(base, theirs) {
+		return ours
+	}
+	if reflect.DeepEqual(base, ours) {
+		return theirs
+	}
+
+	merged := make(map[string]int)
+	for _, diners := range []map[string]int{ours, theirs} {
+		for diner := range diners {
+			if _, done := merged[diner]; done {
+				continue
+			}
+			baseRating, inBase := base[diner]
+			ourRating, inOurs := ours[diner]
+			theirRating, inTheirs := theirs[diner]
+			switch {
+			case inOurs && inTheirs:
+				merged[diner] = mergeValue("dinerRatings["+diner+"]", baseRating, ourRating, theirRating, conflicts)
+			case !inBase && inOurs:
+				merged[diner] = ourRating // Added on ours
+			case !inBase:
+				merged[diner] = theirRating // Added on theirs
+			case inOurs && ourRating != baseRating:
+				// Removed on theirs, but changed on ours
+				*conflicts = append(*conflicts, "dinerRatings["+diner+"]")
+				merged[diner] = ourRating
+			case inTheirs && theirRating != baseRating:
+				*conflicts = append(*conflicts, "dinerRatings["+diner+"]")
+				merged[diner] = theirRating
+			}
+		}
+	}
+	return merged
+}
+
+// mergeSet merges lists without order or duplicates, keeping items added on either side and
+// dropping items removed on either side. Sets never conflict.
+func mergeSet(base, ours, theirs []string) []string {
+	if equalSlices(ours, theirs) || equalSlices(base, theirs) {
+		return ours
+	}
+	if equalSlices(base, ours) {
+		return theirs
+	}
+
+	merged := make([]string, 0, len(ours))
+	for _, item := range union(ours, theirs) {
+		inBase := contains(base, item)
+		if inBase && (!contains(ours, item) || !contains(theirs, item)) {
+			continue // Removed on one side
+		}
+		merged = append(merged, item)
+	}
+	return merged
+}
+
+func contains(items []string, item string) bool {
+	for _, existing := range items {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
+
+// equalSlices is like reflect.DeepEqual, but treats nil and empty slices as equal, as both
+// are found in stored files
+func equalSlices[T any](a, b []T) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// union returns a followed by the items of b not in a
+func union(a, b []string) []string {
+	result := append([]string(nil), a...)
+	for _, item := range b {
+		if !contains(result, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
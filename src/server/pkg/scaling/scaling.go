@@ -0,0 +1,78 @@
+package scaling
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/units"
+)
+
+var ErrNoYield = errors.New("recipe has no yield to scale from")
+
+// yieldStep is what scaled yields are rounded to, e.g. 2.5 servings
+const yieldStep = 0.5
+
+// FactorForServings returns the factor needed to scale the recipe to the given yield amount
+func FactorForServings(recipe models.Recipe, servings float64) (float64, error) {
+	if recipe.Yield == nil || recipe.Yield.Amount <= 0 {
+		return 0, ErrNoYield
+	}
+	return servings / float64(recipe.Yield.Amount), nil
+}
+
+// ScaleRecipe returns a copy of the recipe with every scalable quantity multiplied by factor
+func ScaleRecipe(recipe models.Recipe, factor float64) models.Recipe {
+	scaled := recipe
+	scaled.Ingredients = ScaleIngredients(recipe.Ingredients, factor)
+
+	if recipe.Yield != nil {
+		amount := roundToStep(float64(recipe.Yield.Amount)*factor, yieldStep)
+		// Never scale a yield away completely
+		if amount == 0 && recipe.Yield.Amount > 0 && factor > 0 {
+			amount = yieldStep
+		}
+		scaled.Yield = &models.RecipeYield{
+			Amount: float32(amount),
+			Unit:   recipe.Yield.Unit,
+		}
+	}
+
+	return scaled
+}
+
+func ScaleIngredients(ingredients []models.RecipeIngredient, factor float64) []models.RecipeIngredient {
+	if ingredients == nil {
+		return nil
+	}
+
+	scaled := make([]models.RecipeIngredient, len(ingredients))
+	for i, ingredient := range ingredients {
+		scaled[i] = ingredient
+		if !IsScalable(ingredient) {
+			continue
+		}
+		scaled[i].Quantity = float32(units.Round(float64(ingredient.Quantity)*factor, ingredient.Unit))
+	}
+
+	return scaled
+}
+
+// IsScalable reports whether the quantity of the ingredient should follow the recipe yield
+func IsScalable(ingredient models.RecipeIngredient) bool {
+	if ingredient.Unscalable || ingredient.Quantity == 0 {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(ingredient.Unit)) {
+	case "to taste", "taste", "pinch", "dash":
+		return false
+	}
+
+	return true
+}
+
+func roundToStep(value, step float64) float64 {
+	return math.Round(value/step) * step
+}
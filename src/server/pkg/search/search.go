@@ -0,0 +1,402 @@
+// Package search finds recipes by the words in their title, description, tags, ingredients and
+// steps, forgiving typos and different word endings, and counts facets of what it finds
+package search
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/ratings"
+)
+
+// Field weights, so a word in the title counts for more than the same word in a step
+const (
+	titleWeight       = 5
+	tagWeight         = 3
+	ingredientWeight  = 3
+	descriptionWeight = 1.5
+	stepWeight        = 1
+)
+
+// Matches that are not the word searched for count for less
+const (
+	prefixFactor = 0.8
+	typoFactor   = 0.6
+)
+
+// maxIngredientFacets is how many of the most common ingredients are counted
+const maxIngredientFacets = 30
+
+// Values of the LastCooked facet
+const (
+	CookedWeek  = "week"
+	CookedMonth = "month"
+	CookedYear  = "year"
+	CookedOlder = "older"
+	CookedNever = "never"
+)
+
+// Document is a recipe as it is searched, with the ratings and the last time it was cooked from its logs
+type Document struct {
+	Recipe models.Recipe
+	// Rating is the average rating of the logs, 0 when none are rated
+	Rating     float64
+	LastCooked time.Time
+}
+
+func NewDocument(recipe models.Recipe, rlogs []models.RecipeLog) Document {
+	stats := ratings.Stats(rlogs)
+	doc := Document{Recipe: recipe, Rating: stats.AverageRating}
+	if stats.LastCooked != "" {
+		doc.LastCooked, _ = time.Parse(time.RFC3339, stats.LastCooked)
+	}
+	return doc
+}
+
+// Query narrows the recipes down by words in their text, and by facets. Empty fields match everything.
+type Query struct {
+	Text string
+	// Tags and Ingredients must all be on the recipe, compared without case
+	Tags        []string
+	Ingredients []string
+	MinRating   float64
+	// LastCooked is one of the values of the LastCooked facet
+	LastCooked      string
+	IncludeArchived bool
+}
+
+// Index is an inverted index of recipe documents. It is not safe for concurrent use; callers
+// must keep writes apart from other writes and reads.
+type Index struct {
+	docs map[string]*entry
+	// postings maps each stem to the documents containing it, with its weighted frequency there
+	postings map[string]map[string]float64
+	// words counts the documents each word is in, for finding words close to the ones searched for
+	words map[string]int
+}
+
+type entry struct {
+	Document
+	terms map[string]float64
+	words []string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*entry),
+		postings: make(map[string]map[string]float64),
+		words:    make(map[string]int),
+	}
+}
+
+// Put adds the document, replacing any earlier version of the recipe
+func (idx *Index) Put(doc Document) {
+	idx.Remove(doc.Recipe.Id)
+
+	e := &entry{Document: doc, terms: make(map[string]float64)}
+	seen := make(map[string]bool)
+	add := func(text string, weight float64) {
+		for _, word := range words(text) {
+			if !seen[word] {
+				seen[word] = true
+				e.words = append(e.words, word)
+			}
+			for _, stem := range stems(word) {
+				e.terms[stem] += weight
+			}
+		}
+	}
+
+	recipe := doc.Recipe
+	add(recipe.Title, titleWeight)
+	add(recipe.Description, descriptionWeight)
+	for _, tag := range recipe.Tags {
+		add(tag, tagWeight)
+	}
+	for _, ingredient := range recipe.Ingredients {
+		add(ingredient.Name, ingredientWeight)
+	}
+	for _, step := range recipe.Steps {
+		add(step.Text, stepWeight)
+	}
+
+	for term, frequency := range e.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]float64)
+		}
+		idx.postings[term][recipe.Id] = frequency
+	}
+	for _, word := range e.words {
+		idx.words[word]++
+	}
+	idx.docs[recipe.Id] = e
+}
+
+func (idx *Index) Remove(id string) {
+	e, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range e.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	for _, word := range e.words {
+		if idx.words[word]--; idx.words[word] <= 0 {
+			delete(idx.words, word)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// Search returns the recipes matching every word of the text and every facet of the query.
+// Without text, all recipes matching the facets are returned, ordered by title.
+func (idx *Index) Search(query Query, now time.Time) models.RecipeSearchResult {
+	queryWords := words(query.Text)
+	var scores map[string]float64
+	if len(queryWords) > 0 {
+		scores = idx.score(queryWords)
+	} else if hasWords(query.Text) {
+		// Only stop words, which nothing is indexed under
+		scores = make(map[string]float64)
+	}
+
+	var matches []*entry
+	for id, e := range idx.docs {
+		if scores != nil {
+			if _, ok := scores[id]; !ok {
+				continue
+			}
+		}
+		if query.matches(e, now) {
+			matches = append(matches, e)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if scores[a.Recipe.Id] != scores[b.Recipe.Id] {
+			return scores[a.Recipe.Id] > scores[b.Recipe.Id]
+		}
+		if a.Recipe.Title != b.Recipe.Title {
+			return a.Recipe.Title < b.Recipe.Title
+		}
+		return a.Recipe.Id < b.Recipe.Id
+	})
+
+	result := models.RecipeSearchResult{
+		Total:  len(matches),
+		Hits:   make([]models.RecipeSearchHit, 0, len(matches)),
+		Facets: facets(matches, now),
+	}
+	for _, e := range matches {
+		result.Hits = append(result.Hits, models.RecipeSearchHit{
+			Recipe: e.Recipe,
+			Score:  math.Round(scores[e.Recipe.Id]*1000) / 1000,
+		})
+	}
+	return result
+}
+
+// score ranks the documents containing every word, each scored BM25-like by the best of the
+// stems it matches. Words are also matched by prefix when they are the last word, as it may
+// not be typed out yet, and by words a few typos away.
+func (idx *Index) score(queryWords []string) map[string]float64 {
+	var scores map[string]float64
+	for i, word := range queryWords {
+		expansions := make(map[string]float64)
+		expand := func(w string, factor float64) {
+			for _, stem := range stems(w) {
+				expansions[stem] = max(expansions[stem], factor)
+			}
+		}
+		expand(word, 1)
+
+		last := i == len(queryWords)-1
+		edits := maxEdits(word)
+		for candidate := range idx.words {
+			if candidate == word {
+				continue
+			}
+			if last && len(word) >= 2 && strings.HasPrefix(candidate, word) {
+				expand(candidate, prefixFactor)
+			} else if edits > 0 {
+				if d := editDistance(word, candidate, edits); d <= edits {
+					expand(candidate, math.Pow(typoFactor, float64(d)))
+				}
+			}
+		}
+
+		wordScores := make(map[string]float64)
+		for stem, factor := range expansions {
+			postings := idx.postings[stem]
+			idf := math.Log(1 + (float64(len(idx.docs))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for id, frequency := range postings {
+				if scores != nil {
+					if _, ok := scores[id]; !ok {
+						continue
+					}
+				}
+				wordScores[id] = max(wordScores[id], factor*idf*frequency/(frequency+1.2))
+			}
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for id := range scores {
+			if s, ok := wordScores[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores
+}
+
+func (q Query) matches(e *entry, now time.Time) bool {
+	if e.Recipe.Archived && !q.IncludeArchived {
+		return false
+	}
+	if q.MinRating > 0 && e.Rating < q.MinRating {
+		return false
+	}
+	if q.LastCooked != "" && lastCooked(e.LastCooked, now) != q.LastCooked {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !containsFold(e.Recipe.Tags, tag) {
+			return false
+		}
+	}
+	for _, ingredient := range q.Ingredients {
+		found := false
+		for _, have := range e.Recipe.Ingredients {
+			if normalize(have.Name) == normalize(ingredient) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if normalize(v) == normalize(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize makes facet values compare without case and extra spaces
+func normalize(value string) string {
+	return strings.Join(strings.Fields(strings.ToLower(value)), " ")
+}
+
+// lastCooked returns the LastCooked facet value of a recipe last cooked at the time
+func lastCooked(cooked, now time.Time) string {
+	switch age := now.Sub(cooked); {
+	case cooked.IsZero():
+		return CookedNever
+	case age <= 7*24*time.Hour:
+		return CookedWeek
+	case age <= 30*24*time.Hour:
+		return CookedMonth
+	case age <= 365*24*time.Hour:
+		return CookedYear
+	default:
+		return CookedOlder
+	}
+}
+
+func facets(matches []*entry, now time.Time) models.RecipeFacets {
+	tags := newCounter()
+	ingredients := newCounter()
+	ratingCounts := make(map[string]int)
+	cookedCounts := make(map[string]int)
+	for _, e := range matches {
+		for _, tag := range e.Recipe.Tags {
+			tags.add(tag)
+		}
+		seen := make(map[string]bool)
+		for _, ingredient := range e.Recipe.Ingredients {
+			if key := normalize(ingredient.Name); !seen[key] {
+				seen[key] = true
+				ingredients.add(key)
+			}
+		}
+		rating := "unrated"
+		if e.Rating >= 1 {
+			rating = strconv.Itoa(int(e.Rating))
+		}
+		ratingCounts[rating]++
+		cookedCounts[lastCooked(e.LastCooked, now)]++
+	}
+
+	return models.RecipeFacets{
+		Tags:        tags.top(0),
+		Ingredients: ingredients.top(maxIngredientFacets),
+		Ratings:     ordered(ratingCounts, "5", "4", "3", "2", "1", "unrated"),
+		LastCooked:  ordered(cookedCounts, CookedWeek, CookedMonth, CookedYear, CookedOlder, CookedNever),
+	}
+}
+
+// ordered lists the counts of the values in the given order, leaving out values with no recipes
+func ordered(counts map[string]int, values ...string) []models.FacetCount {
+	facets := make([]models.FacetCount, 0, len(values))
+	for _, value := range values {
+		if counts[value] > 0 {
+			facets = append(facets, models.FacetCount{Value: value, Count: counts[value]})
+		}
+	}
+	return facets
+}
+
+// counter counts values without case, reporting each by the first spelling seen
+type counter struct {
+	counts map[string]int
+	names  map[string]string
+}
+
+func newCounter() *counter {
+	return &counter{counts: make(map[string]int), names: make(map[string]string)}
+}
+
+func (c *counter) add(value string) {
+	key := normalize(value)
+	if _, ok := c.names[key]; !ok {
+		c.names[key] = strings.TrimSpace(value)
+	}
+	c.counts[key]++
+}
+
+// top returns the most frequent values first, at most limit of them unless limit is 0
+func (c *counter) top(limit int) []models.FacetCount {
+	facets := make([]models.FacetCount, 0, len(c.counts))
+	for key, count := range c.counts {
+		facets = append(facets, models.FacetCount{Value: c.names[key], Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	if limit > 0 && len(facets) > limit {
+		facets = facets[:limit]
+	}
+	return facets
+}
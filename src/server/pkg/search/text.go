@@ -0,0 +1,105 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	snowball "github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/danish"
+	"github.com/blevesearch/snowballstem/english"
+)
+
+// stopWords are left out of the index and queries, so "chicken with rice" does not require
+// every recipe to contain "with"
+var stopWords = map[string]bool{
+	// English
+	"a": true, "an": true, "and": true, "as": true, "at": true, "by": true, "for": true, "from": true,
+	"in": true, "into": true, "is": true, "it": true, "of": true, "on": true, "or": true, "the": true,
+	"then": true, "to": true, "with": true,
+	// Danish
+	"af": true, "en": true, "et": true, "den": true, "det": true, "der": true, "er": true, "fra": true,
+	"i": true, "med": true, "og": true, "på": true, "til": true, "ved": true,
+}
+
+// words splits the text into lowercase words, without stop words
+func words(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	words := fields[:0]
+	for _, word := range fields {
+		if !stopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// hasWords reports whether the text has any words, stop words included
+func hasWords(text string) bool {
+	return strings.IndexFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0
+}
+
+// stems returns the English and Danish stems of the word. Recipes don't say which language
+// they are in, so words are indexed and searched under both.
+func stems(word string) []string {
+	en := snowball.NewEnv(word)
+	english.Stem(en)
+	da := snowball.NewEnv(word)
+	danish.Stem(da)
+
+	if en.Current() == da.Current() {
+		return []string{en.Current()}
+	}
+	return []string{en.Current(), da.Current()}
+}
+
+// maxEdits is the number of typos forgiven in a word of the length. Short words are not
+// corrected, as almost any change turns them into another word.
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of adjacent letters
+// needed to turn a into b, giving up once it exceeds limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	// Three rows of the optimal string alignment matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,133 @@
+package units
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+// densities maps ingredient names to their approximate density in grams per millilitre
+var densities = map[string]float64{
+	"water":          1.0,
+	"milk":           1.03,
+	"cream":          1.01,
+	"buttermilk":     1.03,
+	"oil":            0.92,
+	"olive oil":      0.91,
+	"butter":         0.911,
+	"honey":          1.42,
+	"syrup":          1.37,
+	"flour":          0.53,
+	"wheat flour":    0.53,
+	"spelt flour":    0.5,
+	"rye flour":      0.55,
+	"sugar":          0.85,
+	"brown sugar":    0.83,
+	"powdered sugar": 0.56,
+	"icing sugar":    0.56,
+	"salt":           1.2,
+	"rice":           0.85,
+	"oats":           0.41,
+	"rolled oats":    0.41,
+	"cocoa":          0.42,
+	"cocoa powder":   0.42,
+	"baking powder":  0.9,
+	"baking soda":    1.1,
+	"yeast":          0.6,
+}
+
+// Density returns the density of the ingredient in grams per millilitre, if known.
+// The longest entry in the table that the name ends with, as whole words, is used,
+// so "whole wheat flour" falls back to "wheat flour" while "rice vinegar" is unknown.
+// Notes after a comma are ignored, as in "butter, softened".
+func Density(ingredientName string) (float64, bool) {
+	name, _, _ := strings.Cut(strings.ToLower(ingredientName), ",")
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := range words {
+		if d, ok := densities[strings.Join(words[i:], " ")]; ok {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// ToMass converts a volume of the ingredient to grams using the density table
+func ToMass(quantity float64, unit, ingredientName string) (float64, bool) {
+	u, ok := Lookup(unit)
+	if !ok || u.Kind != KindVolume {
+		return 0, false
+	}
+	density, ok := Density(ingredientName)
+	if !ok {
+		return 0, false
+	}
+	return quantity * u.Factor * density, true
+}
+
+// ToBase converts the quantity of an ingredient to the base unit of its kind, so quantities
+// written in different units can be compared or summed. Volumes are converted to grams when
+// the density of the ingredient is known.
+func ToBase(ingredient models.RecipeIngredient) (float64, Kind, bool) {
+	if grams, ok := ToMass(float64(ingredient.Quantity), ingredient.Unit, ingredient.Name); ok {
+		return grams, KindMass, true
+	}
+	u, ok := Lookup(ingredient.Unit)
+	if !ok || u.Kind == KindTemperature {
+		return 0, KindUnknown, false
+	}
+	return float64(ingredient.Quantity) * u.Factor, u.Kind, true
+}
+
+// ConvertIngredient converts the ingredient quantity to the given system. When preferMass is
+// set, volumes of ingredients with a known density are converted to a mass.
+func ConvertIngredient(ingredient models.RecipeIngredient, system System, preferMass bool) models.RecipeIngredient {
+	if preferMass {
+		if grams, ok := ToMass(float64(ingredient.Quantity), ingredient.Unit, ingredient.Name); ok {
+			target := system
+			if target == SystemNone {
+				target = SystemMetric
+			}
+			unit := bestUnit(grams, KindMass, target)
+			ingredient.Quantity = float32(Round(grams/unit.Factor, unit.Name))
+			ingredient.Unit = unit.Name
+			return ingredient
+		}
+	}
+
+	quantity, unit := ToSystem(float64(ingredient.Quantity), ingredient.Unit, system)
+	ingredient.Quantity = float32(quantity)
+	ingredient.Unit = unit
+	return ingredient
+}
+
+// ConvertRecipe returns a copy of the recipe with ingredient quantities and step temperatures
+// converted to the given system
+func ConvertRecipe(recipe models.Recipe, system System, preferMass bool) models.Recipe {
+	converted := recipe
+
+	if recipe.Ingredients != nil {
+		converted.Ingredients = make([]models.RecipeIngredient, len(recipe.Ingredients))
+		for i, ingredient := range recipe.Ingredients {
+			converted.Ingredients[i] = ConvertIngredient(ingredient, system, preferMass)
+		}
+	}
+
+	if recipe.Steps != nil {
+		converted.Steps = make([]models.RecipeStep, len(recipe.Steps))
+		for i, step := range recipe.Steps {
+			converted.Steps[i] = step
+			if step.Temperature != nil {
+				value, unit := ToSystem(float64(step.Temperature.Value), step.Temperature.Unit, system)
+				converted.Steps[i].Temperature = &models.RecipeTemperature{
+					Value: float32(value),
+					Unit:  unit,
+				}
+			}
+		}
+	}
+
+	return converted
+}
@@ -0,0 +1,202 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type Kind int
+
+const (
+	KindUnknown Kind = iota
+	KindMass
+	KindVolume
+	KindCount
+	KindTemperature
+)
+
+type System string
+
+const (
+	SystemNone     System = ""
+	SystemMetric   System = "metric"
+	SystemImperial System = "imperial"
+)
+
+type Unit struct {
+	// Name is the canonical name used when writing the unit back out
+	Name   string
+	Kind   Kind
+	System System
+	// Factor converts a quantity in this unit to the base unit of its kind
+	// (grams, millilitres and pieces). Not used for temperatures.
+	Factor float64
+	// Step is the precision quantities in this unit are rounded to
+	Step    float64
+	Aliases []string
+}
+
+var knownUnits = []Unit{
+	{Name: "mg", Kind: KindMass, System: SystemMetric, Factor: 0.001, Step: 1, Aliases: []string{"milligram", "milligrams"}},
+	{Name: "g", Kind: KindMass, System: SystemMetric, Factor: 1, Step: 1, Aliases: []string{"gr", "gram", "grams", "gramme", "grammes"}},
+	{Name: "kg", Kind: KindMass, System: SystemMetric, Factor: 1000, Step: 0.01, Aliases: []string{"kilo", "kilos", "kilogram", "kilograms"}},
+	{Name: "oz", Kind: KindMass, System: SystemImperial, Factor: 28.349523125, Step: 0.1, Aliases: []string{"ounce", "ounces"}},
+	{Name: "lb", Kind: KindMass, System: SystemImperial, Factor: 453.59237, Step: 0.01, Aliases: []string{"lbs", "pound", "pounds"}},
+
+	{Name: "ml", Kind: KindVolume, System: SystemMetric, Factor: 1, Step: 1, Aliases: []string{"milliliter", "milliliters", "millilitre", "millilitres"}},
+	{Name: "cl", Kind: KindVolume, System: SystemMetric, Factor: 10, Step: 0.1, Aliases: []string{"centiliter", "centiliters", "centilitre", "centilitres"}},
+	{Name: "dl", Kind: KindVolume, System: SystemMetric, Factor: 100, Step: 0.1, Aliases: []string{"deciliter", "deciliters", "decilitre", "decilitres"}},
+	{Name: "l", Kind: KindVolume, System: SystemMetric, Factor: 1000, Step: 0.01, Aliases: []string{"liter", "liters", "litre", "litres"}},
+	{Name: "tsk", Kind: KindVolume, System: SystemMetric, Factor: 5, Step: 0.25, Aliases: []string{"teskefuld"}},
+	{Name: "spsk", Kind: KindVolume, System: SystemMetric, Factor: 15, Step: 0.25, Aliases: []string{"spiseskefuld"}},
+	{Name: "tsp", Kind: KindVolume, System: SystemImperial, Factor: 4.92892159375, Step: 0.25, Aliases: []string{"teaspoon", "teaspoons"}},
+	{Name: "tbsp", Kind: KindVolume, System: SystemImperial, Factor: 14.78676478125, Step: 0.25, Aliases: []string{"tablespoon", "tablespoons"}},
+	{Name: "fl oz", Kind: KindVolume, System: SystemImperial, Factor: 29.5735295625, Step: 0.1, Aliases: []string{"floz", "fluid ounce", "fluid ounces"}},
+	{Name: "cup", Kind: KindVolume, System: SystemImperial, Factor: 236.5882365, Step: 0.25, Aliases: []string{"cups"}},
+	{Name: "pint", Kind: KindVolume, System: SystemImperial, Factor: 473.176473, Step: 0.01, Aliases: []string{"pints", "pt"}},
+	{Name: "quart", Kind: KindVolume, System: SystemImperial, Factor: 946.352946, Step: 0.01, Aliases: []string{"quarts", "qt"}},
+	{Name: "gallon", Kind: KindVolume, System: SystemImperial, Factor: 3785.411784, Step: 0.01, Aliases: []string{"gallons", "gal"}},
+
+	{Name: "pcs", Kind: KindCount, Factor: 1, Step: 0.5, Aliases: []string{"", "pc", "piece", "pieces", "stk", "stk.", "whole"}},
+	{Name: "dozen", Kind: KindCount, Factor: 12, Step: 0.25, Aliases: []string{"dozens"}},
+
+	{Name: "C", Kind: KindTemperature, System: SystemMetric, Step: 1, Aliases: []string{"°c", "celsius", "degc"}},
+	{Name: "F", Kind: KindTemperature, System: SystemImperial, Step: 1, Aliases: []string{"°f", "fahrenheit", "degf"}},
+}
+
+var unitsByAlias = func() map[string]Unit {
+	m := make(map[string]Unit)
+	for _, u := range knownUnits {
+		m[strings.ToLower(u.Name)] = u
+		for _, alias := range u.Aliases {
+			m[alias] = u
+		}
+	}
+	return m
+}()
+
+// Lookup finds a unit by its name or one of its aliases, ignoring case and surrounding whitespace
+func Lookup(name string) (Unit, bool) {
+	u, ok := unitsByAlias[strings.ToLower(strings.TrimSpace(name))]
+	return u, ok
+}
+
+// Normalize returns the canonical name of the unit, or the trimmed input if the unit is unknown
+func Normalize(name string) string {
+	if u, ok := Lookup(name); ok {
+		return u.Name
+	}
+	return strings.TrimSpace(name)
+}
+
+func ParseSystem(s string) (System, error) {
+	switch System(strings.ToLower(s)) {
+	case SystemNone:
+		return SystemNone, nil
+	case SystemMetric:
+		return SystemMetric, nil
+	case SystemImperial:
+		return SystemImperial, nil
+	}
+	return SystemNone, fmt.Errorf("unknown unit system %q", s)
+}
+
+// Convert converts a quantity between two units of the same kind
+func Convert(quantity float64, from, to string) (float64, error) {
+	fromUnit, ok := Lookup(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+	toUnit, ok := Lookup(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+	if fromUnit.Kind != toUnit.Kind {
+		return 0, fmt.Errorf("cannot convert %s to %s", fromUnit.Name, toUnit.Name)
+	}
+
+	if fromUnit.Kind == KindTemperature {
+		return convertTemperature(quantity, fromUnit, toUnit), nil
+	}
+	return quantity * fromUnit.Factor / toUnit.Factor, nil
+}
+
+// Round rounds the quantity to a precision that makes sense when measuring in the unit
+func Round(quantity float64, unit string) float64 {
+	step := 0.01
+	if u, ok := Lookup(unit); ok {
+		step = u.Step
+	}
+	// Small amounts of grams and millilitres are still measured with some precision
+	if step == 1 && quantity < 10 && quantity > -10 {
+		step = 0.1
+	}
+
+	rounded := math.Round(quantity/step) * step
+	// Never round a quantity away completely
+	if rounded == 0 && quantity != 0 {
+		return quantity
+	}
+	return rounded
+}
+
+// ToSystem converts the quantity to the most readable unit of the given system.
+// Quantities in unknown units, counts and quantities already in the system are returned as-is.
+func ToSystem(quantity float64, unit string, system System) (float64, string) {
+	u, ok := Lookup(unit)
+	if !ok || system == SystemNone || u.Kind == KindCount || u.System == system {
+		return quantity, unit
+	}
+
+	if u.Kind == KindTemperature {
+		target := unitsByAlias["c"]
+		if system == SystemImperial {
+			target = unitsByAlias["f"]
+		}
+		return Round(convertTemperature(quantity, u, target), target.Name), target.Name
+	}
+
+	base := quantity * u.Factor
+	target := bestUnit(base, u.Kind, system)
+	converted := base / target.Factor
+	return Round(converted, target.Name), target.Name
+}
+
+func bestUnit(base float64, kind Kind, system System) Unit {
+	var candidates []string
+	switch {
+	case kind == KindMass && system == SystemMetric:
+		candidates = []string{"g", "kg"}
+	case kind == KindMass && system == SystemImperial:
+		candidates = []string{"oz", "lb"}
+	case kind == KindVolume && system == SystemMetric:
+		candidates = []string{"ml", "l"}
+	case kind == KindVolume && system == SystemImperial:
+		candidates = []string{"tsp", "tbsp", "cup"}
+	}
+
+	// Pick the largest unit the quantity is at least one of, e.g. 1.5 kg rather than 1500 g
+	best := unitsByAlias[candidates[0]]
+	for _, name := range candidates[1:] {
+		u := unitsByAlias[name]
+		threshold := u.Factor
+		if kind == KindVolume && name == "cup" {
+			threshold = u.Factor / 4
+		}
+		if base >= threshold {
+			best = u
+		}
+	}
+	return best
+}
+
+func convertTemperature(value float64, from, to Unit) float64 {
+	if from.Name == to.Name {
+		return value
+	}
+	if from.Name == "C" {
+		return value*9/5 + 32
+	}
+	return (value - 32) * 5 / 9
+}
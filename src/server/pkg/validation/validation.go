@@ -0,0 +1,163 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+)
+
+const MaxIdLength = 128
+
+// idRegexp only allows ids that are safe to use as a single path segment in the repository
+var idRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is a list of problems found while validating a request
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+	return "validation failed: " + strings.Join(messages, ", ")
+}
+
+func (e *Errors) add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Err returns nil if there are no errors, so callers can use it as an error value
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// IsValidId reports whether the id can safely be used as a recipe or log id
+func IsValidId(id string) bool {
+	return len(id) <= MaxIdLength && idRegexp.MatchString(id)
+}
+
+func ValidateId(field, id string) Errors {
+	var errs Errors
+	if id == "" {
+		errs.add(field, "must not be empty")
+	} else if !IsValidId(id) {
+		errs.add(field, fmt.Sprintf("must only contain letters, digits, '-' and '_', and be at most %d characters", MaxIdLength))
+	}
+	return errs
+}
+
+// ValidateRecipe validates a recipe from a request body. The id may be empty, in which case it is generated.
+func ValidateRecipe(recipe models.Recipe) Errors {
+	var errs Errors
+
+	if recipe.Id != "" {
+		errs = append(errs, ValidateId("id", recipe.Id)...)
+	}
+	if strings.TrimSpace(recipe.Title) == "" {
+		errs.add("title", "must not be empty")
+	}
+	if recipe.Yield != nil && recipe.Yield.Amount < 0 {
+		errs.add("yield.amount", "must not be negative")
+	}
+	errs = append(errs, validateIngredients("ingredients", recipe.Ingredients)...)
+
+	for i, step := range recipe.Steps {
+		field := fmt.Sprintf("steps[%d]", i)
+		if strings.TrimSpace(step.Text) == "" {
+			errs.add(field+".text", "must not be empty")
+		}
+		if step.DurationMinutes != nil && *step.DurationMinutes < 0 {
+			errs.add(field+".durationMinutes", "must not be negative")
+		}
+	}
+	errs = append(errs, validateTags(recipe.Tags)...)
+	for i, previousId := range recipe.PreviousIds {
+		errs = append(errs, ValidateId(fmt.Sprintf("previousIds[%d]", i), previousId)...)
+	}
+	if recipe.ForkedFrom != nil {
+		errs = append(errs, ValidateId("forkedFrom.recipeId", recipe.ForkedFrom.RecipeId)...)
+	}
+
+	return errs
+}
+
+// ValidateRecipeLog validates a recipe log from a request body. The id may be empty, in which case it is generated.
+func ValidateRecipeLog(rlog models.RecipeLog) Errors {
+	var errs Errors
+
+	if rlog.Id != "" {
+		errs = append(errs, ValidateId("id", rlog.Id)...)
+	}
+	errs = append(errs, ValidateId("recipeId", rlog.RecipeId)...)
+	errs = append(errs, validateIngredients("actualIngredients", rlog.ActualIngredients)...)
+
+	if rlog.Rating < 0 || rlog.Rating > 5 {
+		errs.add("rating", "must be from 1 to 5, or 0 when not rated")
+	}
+	diners := make([]string, 0, len(rlog.DinerRatings))
+	for diner := range rlog.DinerRatings {
+		diners = append(diners, diner)
+	}
+	sort.Strings(diners)
+	for _, diner := range diners {
+		rating := rlog.DinerRatings[diner]
+		field := fmt.Sprintf("dinerRatings[%s]", diner)
+		if strings.TrimSpace(diner) == "" {
+			errs.add(field, "diner must have a name")
+		}
+		if rating < 1 || rating > 5 {
+			errs.add(field, "must be from 1 to 5")
+		}
+	}
+	if rlog.CookTimeMinutes != nil && *rlog.CookTimeMinutes < 0 {
+		errs.add("cookTimeMinutes", "must not be negative")
+	}
+	errs = append(errs, validateTags(rlog.Tags)...)
+
+	return errs
+}
+
+// ValidateIngredientsAtHand validates the ingredients searched for recipes with
+func ValidateIngredientsAtHand(ingredients []models.RecipeIngredient) Errors {
+	var errs Errors
+	if len(ingredients) == 0 {
+		errs.add("ingredients", "must not be empty")
+	}
+	errs = append(errs, validateIngredients("ingredients", ingredients)...)
+	return errs
+}
+
+func validateTags(tags []string) Errors {
+	var errs Errors
+	for i, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			errs.add(fmt.Sprintf("tags[%d]", i), "must not be empty")
+		}
+	}
+	return errs
+}
+
+func validateIngredients(field string, ingredients []models.RecipeIngredient) Errors {
+	var errs Errors
+	for i, ingredient := range ingredients {
+		ingredientField := fmt.Sprintf("%s[%d]", field, i)
+		if strings.TrimSpace(ingredient.Name) == "" {
+			errs.add(ingredientField+".name", "must not be empty")
+		}
+		if ingredient.Quantity < 0 {
+			errs.add(ingredientField+".quantity", "must not be negative")
+		}
+	}
+	return errs
+}
@@ -0,0 +1,92 @@
+package webserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jonasmh/recipetracker/pkg/images"
+)
+
+// defaultMaxImageDimension is the number of pixels images are scaled down to fit within, unless configured
+const defaultMaxImageDimension = 2048
+
+func (s *WebServer) attachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	attachments, err := s.db.GetAttachments(r.PathValue("recipeId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, attachments)
+}
+
+// newAttachmentHandler attaches the file in the body, either as the file field "file" of a
+// multipart form, or as the whole body. Images have their metadata removed and are scaled down
+// before they are stored. Uploading a file the recipe already has returns the existing attachment.
+func (s *WebServer) newAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := readUpload(w, r, "file")
+	if !ok {
+		return
+	}
+	if images.IsImage(data) {
+		cleaned, err := images.Clean(data, s.attachments.MaxDimension)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid image: "+err.Error())
+			return
+		}
+		data = cleaned
+	}
+
+	attachment, err := s.db.AddAttachment(r.PathValue("recipeId"), data, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, attachment)
+}
+
+func (s *WebServer) attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachment, data, err := s.db.GetAttachment(r.PathValue("recipeId"), r.PathValue("attachmentId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	serveImmutable(w, r, attachment.ContentType, `"`+attachment.Id+`"`, data)
+}
+
+// attachmentThumbnailHandler scales an image attachment down to fit within ?size= pixels, 256 by default
+func (s *WebServer) attachmentThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	size, ok := thumbnailSize(w, r)
+	if !ok {
+		return
+	}
+
+	attachment, data, err := s.db.GetAttachment(r.PathValue("recipeId"), r.PathValue("attachmentId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+	if !strings.HasPrefix(attachment.ContentType, "image/") {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "Attachment is not an image")
+		return
+	}
+	thumbnail, err := images.Thumbnail(data, size)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	serveImmutable(w, r, "image/jpeg", thumbnailETag(attachment.Id, size), thumbnail)
+}
+
+func (s *WebServer) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.db.DeleteAttachment(r.PathValue("recipeId"), r.PathValue("attachmentId"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/validation"
+)
+
+func (s *WebServer) mergeConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	conflicts, err := s.db.GetMergeConflicts()
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, conflicts)
+}
+
+func (s *WebServer) mergeConflictHandler(w http.ResponseWriter, r *http.Request) {
+	conflict, err := s.db.GetMergeConflict(r.PathValue("conflictId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, conflict)
+}
+
+// resolveMergeConflictHandler resolves a conflict with one of the stored versions, chosen with
+// ?take=ours|theirs|merged, or with the recipe or log in the body. A null body deletes the file.
+func (s *WebServer) resolveMergeConflictHandler(w http.ResponseWriter, r *http.Request) {
+	conflict, err := s.db.GetMergeConflict(r.PathValue("conflictId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	var resolved json.RawMessage
+	switch take := r.URL.Query().Get("take"); take {
+	case "ours":
+		resolved = conflict.Ours
+	case "theirs":
+		resolved = conflict.Theirs
+	case "merged":
+		resolved = conflict.Merged
+	case "":
+		if !decodeJSON(w, r, &resolved) {
+			return
+		}
+		if errs := validateResolved(conflict, resolved); len(errs) > 0 {
+			writeValidationError(w, r, errs)
+			return
+		}
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "take must be ours, theirs or merged")
+		return
+	}
+
+	err = s.db.ResolveMergeConflict(conflict.Id, resolved, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+}
+
+// validateResolved checks that the resolved contents are a valid recipe or log for the conflicting file
+func validateResolved(conflict models.MergeConflict, resolved json.RawMessage) validation.Errors {
+	if conflict.RecipeId == "" || bytes.Equal(bytes.TrimSpace(resolved), []byte("null")) {
+		return nil
+	}
+
+	if conflict.LogId != "" {
+		var rlog models.RecipeLog
+		if err := json.Unmarshal(resolved, &rlog); err != nil {
+			return validation.Errors{{Field: "body", Message: "must be a recipe log"}}
+		}
+		rlog.Id = conflict.LogId
+		rlog.RecipeId = conflict.RecipeId
+		return validation.ValidateRecipeLog(rlog)
+	}
+
+	var recipe models.Recipe
+	if err := json.Unmarshal(resolved, &recipe); err != nil {
+		return validation.Errors{{Field: "body", Message: "must be a recipe"}}
+	}
+	recipe.Id = conflict.RecipeId
+	return validation.ValidateRecipe(recipe)
+}
@@ -0,0 +1,145 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jonasmh/recipetracker/pkg/database"
+	"github.com/jonasmh/recipetracker/pkg/validation"
+)
+
+// maxBodyBytes is the largest request body accepted by the API, except for uploads
+const maxBodyBytes = 1 << 20
+
+// maxUploadBytes is the largest file that can be uploaded
+const maxUploadBytes = 20 << 20
+
+// Error codes returned in errorResponse.Code
+const (
+	codeInvalid           = "invalid"
+	codeNotFound          = "not_found"
+	codeConflict          = "conflict"
+	codePrecondition      = "precondition_failed"
+	codeTooLarge          = "too_large"
+	codeRemoteUnavailable = "remote_unavailable"
+	codeUnsupported       = "unsupported"
+	codeInternal          = "internal"
+)
+
+type errorResponse struct {
+	Code      string                  `json:"code"`
+	Message   string                  `json:"message"`
+	RequestId string                  `json:"requestId"`
+	Errors    []validation.FieldError `json:"errors,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, fieldErrors ...validation.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Code:      code,
+		Message:   message,
+		RequestId: middleware.GetReqID(r.Context()),
+		Errors:    fieldErrors,
+	})
+}
+
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs validation.Errors) {
+	writeError(w, r, http.StatusBadRequest, codeInvalid, "Validation failed", errs...)
+}
+
+// writeDbError maps errors from the database to a status code and error response.
+// Unexpected errors are logged, and not shown to the client.
+func writeDbError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrs validation.Errors
+	switch {
+	case errors.As(err, &validationErrs):
+		writeValidationError(w, r, validationErrs)
+	case errors.Is(err, database.ErrNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, database.ErrConflict):
+		writeError(w, r, http.StatusConflict, codeConflict, err.Error())
+	case errors.Is(err, database.ErrPreconditionFailed):
+		writeError(w, r, http.StatusPreconditionFailed, codePrecondition, err.Error())
+	case errors.Is(err, database.ErrInvalid):
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+	case errors.Is(err, database.ErrRemoteUnavailable):
+		writeError(w, r, http.StatusBadGateway, codeRemoteUnavailable, err.Error())
+	case errors.Is(err, database.ErrUnsupported):
+		writeError(w, r, http.StatusNotImplemented, codeUnsupported, err.Error())
+	default:
+		slog.Error("Request failed", "requestId", middleware.GetReqID(r.Context()), "path", r.URL.Path, "err", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "err", err)
+	}
+}
+
+// decodeJSON decodes the request body into v, writing an error response if it is not valid JSON
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeTooLarge(w, r, maxBytesErr)
+			return false
+		}
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// requestIdHeader echoes the id of the request back to the client, so it can be matched with the server log
+func requestIdHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps the size of request bodies
+func limitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeTooLarge(w http.ResponseWriter, r *http.Request, err *http.MaxBytesError) {
+	writeError(w, r, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("Request body must be at most %d bytes", err.Limit))
+}
+
+// validatePathIds rejects requests where a {...Id} URL parameter is not a safe id, before it
+// is used to build a path in the repository
+func validatePathIds(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var errs validation.Errors
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			for i, key := range rctx.URLParams.Keys {
+				if strings.HasSuffix(key, "Id") {
+					errs = append(errs, validation.ValidateId(key, rctx.URLParams.Values[i])...)
+				}
+			}
+		}
+		if len(errs) > 0 {
+			writeValidationError(w, r, errs)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,153 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/validation"
+)
+
+func (s *WebServer) experimentsHandler(w http.ResponseWriter, r *http.Request) {
+	experiments, err := s.db.GetExperiments(r.PathValue("recipeId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, experiments)
+}
+
+// newExperimentHandler starts an experiment named with ?id= from the current recipe
+func (s *WebServer) newExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if errs := validation.ValidateId("id", id); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	experiment, err := s.db.CreateExperiment(r.PathValue("recipeId"), id)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, experiment)
+}
+
+func (s *WebServer) experimentHandler(w http.ResponseWriter, r *http.Request) {
+	experiment, err := s.db.GetExperiment(r.PathValue("recipeId"), r.PathValue("experimentId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, experiment)
+}
+
+func (s *WebServer) discardExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.db.DiscardExperiment(r.PathValue("recipeId"), r.PathValue("experimentId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *WebServer) experimentRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	convert, err := unitConverter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+		return
+	}
+
+	recipe, err := s.db.GetExperimentRecipe(r.PathValue("recipeId"), r.PathValue("experimentId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convert(recipe))
+}
+
+func (s *WebServer) updateExperimentRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	var recipe models.Recipe
+	if !decodeJSON(w, r, &recipe) {
+		return
+	}
+	recipe.Id = r.PathValue("recipeId")
+	if errs := validation.ValidateRecipe(recipe); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	experimentId := r.PathValue("experimentId")
+	err := s.db.UpdateExperimentRecipe(experimentId, recipe, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	updated, err := s.db.GetExperimentRecipe(recipe.Id, experimentId)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, updated)
+}
+
+func (s *WebServer) experimentLogsHandler(w http.ResponseWriter, r *http.Request) {
+	rlogs, err := s.db.GetExperimentLogs(r.PathValue("recipeId"), r.PathValue("experimentId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, rlogs)
+}
+
+func (s *WebServer) newExperimentLogHandler(w http.ResponseWriter, r *http.Request) {
+	var rlog models.RecipeLog
+	if !decodeJSON(w, r, &rlog) {
+		return
+	}
+	if rlog.RecipeId == "" {
+		rlog.RecipeId = r.PathValue("recipeId")
+	}
+	errs := validation.ValidateRecipeLog(rlog)
+	if rlog.RecipeId != r.PathValue("recipeId") {
+		errs = append(errs, validation.FieldError{Field: "recipeId", Message: "must match the recipe in the URL"})
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	rlog, err := s.db.AddExperimentLog(r.PathValue("experimentId"), rlog, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, rlog)
+}
+
+// mergeExperimentHandler merges the experiment into the recipe, and returns the merged recipe.
+// Conflicts with changes made to the recipe since the experiment started are listed in /api/db/conflicts.
+func (s *WebServer) mergeExperimentHandler(w http.ResponseWriter, r *http.Request) {
+	recipeId := r.PathValue("recipeId")
+	err := s.db.MergeExperiment(recipeId, r.PathValue("experimentId"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	recipe, err := s.db.GetRecipe(recipeId)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, recipe)
+}
@@ -0,0 +1,185 @@
+package webserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/paging"
+)
+
+const maxPageLimit = 500
+
+var (
+	recipeSortKeys = []string{"title", "modified", "cooked", "rating"}
+	searchSortKeys = []string{"score", "title"}
+	logSortKeys    = []string{"created", "modified", "rating"}
+)
+
+// listParams are how a list endpoint sorts and pages its items, and which of their fields it returns
+type listParams struct {
+	sort  paging.Sort
+	after *paging.Cursor
+	// limit is the size of a page, 0 to list everything after the cursor
+	limit int
+	// fields are the JSON fields of the items to return, all of them when empty
+	fields []string
+}
+
+// readListParams reads ?sort=, ?cursor=, ?limit= and ?fields=, writing an error response if they
+// are invalid. Fields must be JSON fields of the model listed.
+func readListParams(w http.ResponseWriter, r *http.Request, defaultSort string, sortKeys []string, model any) (listParams, bool) {
+	query := r.URL.Query()
+	var params listParams
+	var err error
+	if params.sort, err = paging.ParseSort(query.Get("sort"), defaultSort, sortKeys...); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+		return params, false
+	}
+	if value := query.Get("cursor"); value != "" {
+		if params.after, err = paging.ParseCursor(value, params.sort); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+			return params, false
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		params.limit, err = strconv.Atoi(value)
+		if err != nil || params.limit < 1 || params.limit > maxPageLimit {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "limit must be from 1 to "+strconv.Itoa(maxPageLimit))
+			return params, false
+		}
+	}
+	if value := query.Get("fields"); value != "" {
+		known := jsonFields(reflect.TypeOf(model))
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if !known[field] {
+				writeError(w, r, http.StatusBadRequest, codeInvalid, "Unknown field: "+field)
+				return params, false
+			}
+			params.fields = append(params.fields, field)
+		}
+	}
+	return params, true
+}
+
+// jsonFields returns the names of the JSON fields of a struct
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// selectFields returns the items with only the fields asked for, or the items as they are if all are
+func selectFields[T any](items []T, params listParams) ([]any, error) {
+	if len(params.fields) == 0 {
+		all := make([]any, len(items))
+		for i, item := range items {
+			all[i] = item
+		}
+		return all, nil
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var all []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	selected := make([]any, len(all))
+	for i, item := range all {
+		fields := make(map[string]json.RawMessage, len(params.fields))
+		for _, field := range params.fields {
+			if value, ok := item[field]; ok {
+				fields[field] = value
+			}
+		}
+		selected[i] = fields
+	}
+	return selected, nil
+}
+
+// writePage writes a page of items with the fields asked for, linking to the next page.
+// envelope wraps the items in the response body, or is nil to write them as they are.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T, next *paging.Cursor, params listParams, envelope func(items []any) any) {
+	selected, err := selectFields(items, params)
+	if err != nil {
+		slog.Error("Failed to select fields", "requestId", middleware.GetReqID(r.Context()), "err", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to select fields")
+		return
+	}
+	var body any = selected
+	if envelope != nil {
+		body = envelope(selected)
+	}
+	if next != nil {
+		u := *r.URL
+		query := u.Query()
+		query.Set("cursor", next.String())
+		u.RawQuery = query.Encode()
+		w.Header().Set("Link", "<"+u.RequestURI()+`>; rel="next"`)
+	}
+	writeJSON(w, body)
+}
+
+func recipeEntry(key string) func(models.RecipeListing) paging.Entry {
+	return func(listing models.RecipeListing) paging.Entry {
+		entry := paging.Entry{Id: listing.Recipe.Id}
+		switch key {
+		case "title":
+			entry.Key = paging.TextKey(listing.Recipe.Title)
+		case "modified":
+			entry.Key = paging.TimeKey(listing.LastModified)
+		case "cooked":
+			entry.Key = paging.TimeKey(listing.LastCooked)
+		case "rating":
+			entry.Key = paging.NumberKey(listing.Rating)
+		}
+		return entry
+	}
+}
+
+func searchEntry(key string) func(models.RecipeSearchHit) paging.Entry {
+	return func(hit models.RecipeSearchHit) paging.Entry {
+		entry := paging.Entry{Id: hit.Recipe.Id}
+		switch key {
+		case "score":
+			entry.Key = paging.NumberKey(hit.Score)
+		case "title":
+			entry.Key = paging.TextKey(hit.Recipe.Title)
+		}
+		return entry
+	}
+}
+
+// logEntry sorts logs by their commits. Logs created in the same second, and logs in stores
+// without history, are sorted by id.
+func logEntry(key string) func(models.RecipeLog) paging.Entry {
+	return func(rlog models.RecipeLog) paging.Entry {
+		entry := paging.Entry{Id: rlog.Id}
+		switch key {
+		case "created":
+			if rlog.CreatedCommit != nil {
+				entry.Key = paging.TimeKey(rlog.CreatedCommit.Author.When)
+			}
+		case "modified":
+			if rlog.Commit != nil {
+				entry.Key = paging.TimeKey(rlog.Commit.Author.When)
+			}
+		case "rating":
+			entry.Key = paging.NumberKey(float64(rlog.Rating))
+		}
+		return entry
+	}
+}
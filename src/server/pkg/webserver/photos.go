@@ -0,0 +1,170 @@
+package webserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jonasmh/recipetracker/pkg/images"
+)
+
+const (
+	defaultThumbnailSize = 256
+	maxThumbnailSize     = 1024
+)
+
+func (s *WebServer) logPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	photos, err := s.db.GetLogPhotos(r.PathValue("recipeId"), r.PathValue("logId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, photos)
+}
+
+// newLogPhotoHandler stores the image in the body, either as the file field "photo" of a
+// multipart form, or as the whole body. Like image attachments, photos have their metadata
+// removed and are scaled down before they are stored.
+func (s *WebServer) newLogPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := readUpload(w, r, "photo")
+	if !ok {
+		return
+	}
+	data, err := images.Clean(data, s.attachments.MaxDimension)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid image: "+err.Error())
+		return
+	}
+
+	photo, err := s.db.AddLogPhoto(r.PathValue("recipeId"), r.PathValue("logId"), data, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, photo)
+}
+
+func (s *WebServer) logPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	photo, data, err := s.db.GetLogPhoto(r.PathValue("recipeId"), r.PathValue("logId"), r.PathValue("photoId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	serveImmutable(w, r, photo.ContentType, `"`+photo.Id+`"`, data)
+}
+
+// logPhotoThumbnailHandler scales the photo down to fit within ?size= pixels, 256 by default
+func (s *WebServer) logPhotoThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	size, ok := thumbnailSize(w, r)
+	if !ok {
+		return
+	}
+
+	photo, data, err := s.db.GetLogPhoto(r.PathValue("recipeId"), r.PathValue("logId"), r.PathValue("photoId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+	thumbnail, err := images.Thumbnail(data, size)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	serveImmutable(w, r, "image/jpeg", thumbnailETag(photo.Id, size), thumbnail)
+}
+
+func (s *WebServer) deleteLogPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.db.DeleteLogPhoto(r.PathValue("recipeId"), r.PathValue("logId"), r.PathValue("photoId"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// thumbnailSize reads the ?size= of a thumbnail, writing an error response if it is invalid
+func thumbnailSize(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("size")
+	if value == "" {
+		return defaultThumbnailSize, true
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 || size > maxThumbnailSize {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "size must be from 1 to "+strconv.Itoa(maxThumbnailSize))
+		return 0, false
+	}
+	return size, true
+}
+
+func thumbnailETag(id string, size int) string {
+	return `"` + id + "-" + strconv.Itoa(size) + `"`
+}
+
+// serveImmutable serves content that never changes for the given ETag, so clients can cache it for good
+func serveImmutable(w http.ResponseWriter, r *http.Request, contentType, etag string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// readUpload reads an uploaded file, from the field of a multipart form or the whole body,
+// writing an error response if it can't be read
+func readUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, bool) {
+	body := io.Reader(r.Body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid multipart form: "+err.Error())
+			return nil, false
+		}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				if !writeUploadError(w, r, err) {
+					writeError(w, r, http.StatusBadRequest, codeInvalid, "Missing file field "+field)
+				}
+				return nil, false
+			}
+			if part.FormName() == field {
+				body = part
+				break
+			}
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		if !writeUploadError(w, r, err) {
+			slog.Error("Failed to read upload", "requestId", middleware.GetReqID(r.Context()), "err", err)
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "Failed to read upload")
+		}
+		return nil, false
+	}
+	if len(data) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "Upload is empty")
+		return nil, false
+	}
+	return data, true
+}
+
+// writeUploadError writes the response for an upload that is too large, and reports whether it did
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeTooLarge(w, r, maxBytesErr)
+		return true
+	}
+	return false
+}
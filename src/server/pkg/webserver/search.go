@@ -0,0 +1,136 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/paging"
+	"github.com/jonasmh/recipetracker/pkg/pantry"
+	"github.com/jonasmh/recipetracker/pkg/search"
+	"github.com/jonasmh/recipetracker/pkg/validation"
+)
+
+type cookableRequest struct {
+	Ingredients []models.RecipeIngredient `json:"ingredients"`
+}
+
+// cookableRecipesHandler ranks the recipes by how many of their ingredients are in the body, as
+// {"ingredients": [{"name": "egg", "quantity": 6}, {"name": "scallions"}]}, reporting what is
+// missing from each. ?maxMissing= leaves out recipes missing more ingredients than that.
+func (s *WebServer) cookableRecipesHandler(w http.ResponseWriter, r *http.Request) {
+	var request cookableRequest
+	if !decodeJSON(w, r, &request) {
+		return
+	}
+	if errs := validation.ValidateIngredientsAtHand(request.Ingredients); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	maxMissing := -1
+	if value := r.URL.Query().Get("maxMissing"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid maxMissing: "+value)
+			return
+		}
+		maxMissing = n
+	}
+
+	recipes, err := s.db.GetRecipes(r.URL.Query().Get("archived") == "true")
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	cookable := pantry.Rank(recipes, request.Ingredients)
+	if maxMissing >= 0 {
+		filtered := cookable[:0]
+		for _, recipe := range cookable {
+			if len(recipe.Missing) <= maxMissing {
+				filtered = append(filtered, recipe)
+			}
+		}
+		cookable = filtered
+	}
+
+	writeJSON(w, cookable)
+}
+
+// recipeSearchPage is a page of models.RecipeSearchResult, with the fields of the recipes asked for
+type recipeSearchPage struct {
+	Total  int                 `json:"total"`
+	Hits   []recipeSearchHit   `json:"hits"`
+	Facets models.RecipeFacets `json:"facets"`
+}
+
+type recipeSearchHit struct {
+	Recipe any     `json:"recipe"`
+	Score  float64 `json:"score"`
+}
+
+// searchRecipes writes the recipes matching the query, best match first when searching for text.
+// Total and facets are of all matches, not just the page.
+func (s *WebServer) searchRecipes(w http.ResponseWriter, r *http.Request, query search.Query, convert func(models.Recipe) models.Recipe) {
+	defaultSort := "title"
+	if query.Text != "" {
+		defaultSort = "-score"
+	}
+	params, ok := readListParams(w, r, defaultSort, searchSortKeys, models.Recipe{})
+	if !ok {
+		return
+	}
+	result, err := s.db.SearchRecipes(query)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	hits, next := paging.Page(result.Hits, searchEntry(params.sort.Key), params.sort, params.after, params.limit)
+	recipes := make([]models.Recipe, len(hits))
+	for i, hit := range hits {
+		recipes[i] = convert(hit.Recipe)
+	}
+	writePage(w, r, recipes, next, params, func(recipes []any) any {
+		page := recipeSearchPage{Total: result.Total, Hits: make([]recipeSearchHit, len(hits)), Facets: result.Facets}
+		for i, hit := range hits {
+			page.Hits[i] = recipeSearchHit{Recipe: recipes[i], Score: hit.Score}
+		}
+		return page
+	})
+}
+
+// searchQuery reads a search from the query parameters. ok is false when none are given.
+func searchQuery(r *http.Request) (query search.Query, ok bool, err error) {
+	params := r.URL.Query()
+	for _, key := range []string{"q", "tag", "ingredient", "minRating", "cooked"} {
+		if params.Has(key) {
+			ok = true
+		}
+	}
+	if !ok {
+		return query, false, nil
+	}
+
+	query = search.Query{
+		Text:            params.Get("q"),
+		Tags:            params["tag"],
+		Ingredients:     params["ingredient"],
+		LastCooked:      params.Get("cooked"),
+		IncludeArchived: params.Get("archived") == "true",
+	}
+	if value := params.Get("minRating"); value != "" {
+		query.MinRating, err = strconv.ParseFloat(value, 64)
+		// Written so NaN, which compares false to everything, is rejected too
+		if err != nil || !(query.MinRating >= 0 && query.MinRating <= 5) {
+			return query, true, errors.New("minRating must be a number from 0 to 5")
+		}
+	}
+	switch query.LastCooked {
+	case "", search.CookedWeek, search.CookedMonth, search.CookedYear, search.CookedOlder, search.CookedNever:
+	default:
+		return query, true, errors.New("cooked must be one of week, month, year, older or never")
+	}
+	return query, true, nil
+}
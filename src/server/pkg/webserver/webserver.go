@@ -1,27 +1,37 @@
 package webserver
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/httplog/v2"
 	"github.com/jonasmh/recipetracker/pkg/config"
 	"github.com/jonasmh/recipetracker/pkg/database"
 	"github.com/jonasmh/recipetracker/pkg/models"
+	"github.com/jonasmh/recipetracker/pkg/paging"
+	"github.com/jonasmh/recipetracker/pkg/ratings"
+	"github.com/jonasmh/recipetracker/pkg/scaling"
+	"github.com/jonasmh/recipetracker/pkg/units"
+	"github.com/jonasmh/recipetracker/pkg/validation"
 )
 
 type WebServer struct {
-	r    *chi.Mux
-	db   *database.RecipeDatabase
-	Port string
+	r           *chi.Mux
+	db          database.Store
+	attachments config.AttachmentsConfig
+	Port        string
 }
 
-func New(cfg *config.Config, db *database.RecipeDatabase) *WebServer {
+func New(cfg *config.Config, db database.Store) *WebServer {
 	logger := httplog.NewLogger("httplog-example", httplog.Options{
 		// JSON:             true,
 		LogLevel:         slog.LevelWarn,
@@ -33,22 +43,81 @@ func New(cfg *config.Config, db *database.RecipeDatabase) *WebServer {
 	})
 
 	server := WebServer{
-		r:    chi.NewRouter(),
-		Port: cfg.Server.Port,
-		db:   db,
+		r:           chi.NewRouter(),
+		Port:        cfg.Server.Port,
+		db:          db,
+		attachments: cfg.Attachments,
+	}
+	if server.attachments.MaxSize <= 0 {
+		server.attachments.MaxSize = maxUploadBytes
+	}
+	if server.attachments.MaxDimension <= 0 {
+		server.attachments.MaxDimension = defaultMaxImageDimension
 	}
 
+	// RequestLogger also assigns every request an id, used in error responses
 	server.r.Use(httplog.RequestLogger(logger))
-	server.r.Post("/api/db/push", server.dbPushHandler)
-	server.r.Post("/api/db/pull", server.dbPullHandler)
-	server.r.Get("/api/recipes", server.listRecipesHandler)
-	server.r.Post("/api/recipes", server.newRecipeHandler)
-	server.r.Get("/api/recipes/{recipeId}", server.recipeHandler)
-	server.r.Get("/api/recipes/{recipeId}/history", server.recipeHistoryHandler)
-	server.r.Get("/api/recipes/{recipeId}/logs", server.recipeLogsHandler)
-	server.r.Post("/api/recipes/{recipeId}/logs", server.newRecipeLogHandler)
-	server.r.Get("/api/recipes/{recipeId}/logs/{logId}", server.recipeLogHandler)
-	server.r.Delete("/api/recipes/{recipeId}/logs/{logId}", server.deleteRecipeLogHandler)
+	server.r.Use(requestIdHeader)
+	server.r.Group(func(api chi.Router) {
+		api.Use(validatePathIds)
+		api.Use(limitBody(maxBodyBytes))
+		api.Get("/api/db/status", server.dbStatusHandler)
+		api.Post("/api/db/push", server.dbPushHandler)
+		api.Post("/api/db/pull", server.dbPullHandler)
+		api.Post("/api/db/reindex", server.dbReindexHandler)
+		api.Get("/api/db/conflicts", server.mergeConflictsHandler)
+		api.Get("/api/db/conflicts/{conflictId}", server.mergeConflictHandler)
+		api.Post("/api/db/conflicts/{conflictId}/resolve", server.resolveMergeConflictHandler)
+		api.Get("/api/recipes", server.listRecipesHandler)
+		api.Post("/api/recipes", server.newRecipeHandler)
+		api.Post("/api/recipes/cookable", server.cookableRecipesHandler)
+		api.Get("/api/recipes/{recipeId}", server.recipeHandler)
+		api.Delete("/api/recipes/{recipeId}", server.deleteRecipeHandler)
+		api.Post("/api/recipes/{recipeId}/rename", server.renameRecipeHandler)
+		api.Post("/api/recipes/{recipeId}/archive", server.archiveRecipeHandler)
+		api.Post("/api/recipes/{recipeId}/unarchive", server.unarchiveRecipeHandler)
+		api.Post("/api/recipes/{recipeId}/fork", server.forkRecipeHandler)
+		api.Get("/api/recipes/{recipeId}/family", server.recipeFamilyHandler)
+		api.Get("/api/recipes/{recipeId}/scaled", server.scaledRecipeHandler)
+		api.Get("/api/recipes/{recipeId}/history", server.recipeHistoryHandler)
+		api.Get("/api/recipes/{recipeId}/diff", server.recipeDiffHandler)
+		api.Get("/api/recipes/{recipeId}/versions/{hash}", server.recipeVersionHandler)
+		api.Post("/api/recipes/{recipeId}/versions/{hash}/restore", server.restoreRecipeVersionHandler)
+		api.Get("/api/recipes/{recipeId}/experiments", server.experimentsHandler)
+		api.Post("/api/recipes/{recipeId}/experiments", server.newExperimentHandler)
+		api.Get("/api/recipes/{recipeId}/experiments/{experimentId}", server.experimentHandler)
+		api.Delete("/api/recipes/{recipeId}/experiments/{experimentId}", server.discardExperimentHandler)
+		api.Get("/api/recipes/{recipeId}/experiments/{experimentId}/recipe", server.experimentRecipeHandler)
+		api.Put("/api/recipes/{recipeId}/experiments/{experimentId}/recipe", server.updateExperimentRecipeHandler)
+		api.Get("/api/recipes/{recipeId}/experiments/{experimentId}/logs", server.experimentLogsHandler)
+		api.Post("/api/recipes/{recipeId}/experiments/{experimentId}/logs", server.newExperimentLogHandler)
+		api.Post("/api/recipes/{recipeId}/experiments/{experimentId}/merge", server.mergeExperimentHandler)
+		api.Get("/api/recipes/{recipeId}/drift", server.recipeDriftHandler)
+		api.Get("/api/recipes/{recipeId}/attachments", server.attachmentsHandler)
+		api.Get("/api/recipes/{recipeId}/attachments/{attachmentId}", server.attachmentHandler)
+		api.Get("/api/recipes/{recipeId}/attachments/{attachmentId}/thumbnail", server.attachmentThumbnailHandler)
+		api.Delete("/api/recipes/{recipeId}/attachments/{attachmentId}", server.deleteAttachmentHandler)
+		api.Get("/api/recipes/{recipeId}/logs", server.recipeLogsHandler)
+		api.Post("/api/recipes/{recipeId}/logs", server.newRecipeLogHandler)
+		api.Get("/api/recipes/{recipeId}/logs/{logId}", server.recipeLogHandler)
+		api.Put("/api/recipes/{recipeId}/logs/{logId}", server.updateRecipeLogHandler)
+		api.Delete("/api/recipes/{recipeId}/logs/{logId}", server.deleteRecipeLogHandler)
+		api.Post("/api/recipes/{recipeId}/logs/{logId}/promote", server.promoteRecipeLogHandler)
+		api.Get("/api/recipes/{recipeId}/logs/{logId}/photos", server.logPhotosHandler)
+		api.Get("/api/recipes/{recipeId}/logs/{logId}/photos/{photoId}", server.logPhotoHandler)
+		api.Get("/api/recipes/{recipeId}/logs/{logId}/photos/{photoId}/thumbnail", server.logPhotoThumbnailHandler)
+		api.Delete("/api/recipes/{recipeId}/logs/{logId}/photos/{photoId}", server.deleteLogPhotoHandler)
+	})
+	server.r.Group(func(uploads chi.Router) {
+		uploads.Use(validatePathIds)
+		uploads.Use(limitBody(maxUploadBytes))
+		uploads.Post("/api/recipes/{recipeId}/logs/{logId}/photos", server.newLogPhotoHandler)
+	})
+	server.r.Group(func(uploads chi.Router) {
+		uploads.Use(validatePathIds)
+		uploads.Use(limitBody(server.attachments.MaxSize))
+		uploads.Post("/api/recipes/{recipeId}/attachments", server.newAttachmentHandler)
+	})
 
 	if cfg.Frontend.EnableProxy {
 		slog.Info("Proxying requests to frontend dev server at", "endpoint", "http://localhost:3000")
@@ -60,7 +129,7 @@ func New(cfg *config.Config, db *database.RecipeDatabase) *WebServer {
 		server.r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 			// Only fallback for non-API routes
 			if len(r.URL.Path) >= 4 && r.URL.Path[:4] == "/api" {
-				http.NotFound(w, r)
+				writeError(w, r, http.StatusNotFound, codeNotFound, "No such endpoint")
 				return
 			}
 			// Try to serve the static file
@@ -79,10 +148,28 @@ func New(cfg *config.Config, db *database.RecipeDatabase) *WebServer {
 	return &server
 }
 
-func (s *WebServer) ListenAndServe() error {
-	err := http.ListenAndServe(":"+s.Port, s.r)
-	if err != nil {
+// shutdownTimeout is how long requests in flight get to finish when the server is stopped
+const shutdownTimeout = 10 * time.Second
+
+// ListenAndServe serves until the context is done, then waits for requests in flight to finish
+func (s *WebServer) ListenAndServe(ctx context.Context) error {
+	server := &http.Server{Addr: ":" + s.Port, Handler: s.r}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
 		return errors.Join(err, errors.New("failed to start server"))
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return errors.Join(err, errors.New("failed to shut down server"))
 	}
 	return nil
 }
@@ -103,68 +190,270 @@ func proxyToHost(target string) http.HandlerFunc {
 	}
 }
 
+// unitConverter returns a function converting recipes to the unit system requested with the
+// units (metric/imperial) and preferMass query parameters
+func unitConverter(r *http.Request) (func(models.Recipe) models.Recipe, error) {
+	system, err := units.ParseSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		return nil, err
+	}
+	preferMass := r.URL.Query().Get("preferMass") == "true"
+
+	return func(recipe models.Recipe) models.Recipe {
+		if system == units.SystemNone && !preferMass {
+			return recipe
+		}
+		return units.ConvertRecipe(recipe, system, preferMass)
+	}, nil
+}
+
+// listRecipesHandler lists the recipes. When searching with any of the q, tag, ingredient,
+// minRating or cooked query parameters, the matching recipes are returned with facets as
+// {"total": n, "hits": [{"recipe": {...}, "score": n}], "facets": {...}}, best match first.
+// Otherwise they are sorted by ?sort= title, modified, cooked or rating. Either way they are paged
+// with ?limit= and the ?cursor= of the Link header, and ?fields= picks the fields of the recipes.
 func (s *WebServer) listRecipesHandler(w http.ResponseWriter, r *http.Request) {
-	recipes, err := s.db.GetRecipes()
+	convert, err := unitConverter(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipes); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if query, ok, err := searchQuery(r); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+		return
+	} else if ok {
+		s.searchRecipes(w, r, query, convert)
+		return
+	}
+
+	params, ok := readListParams(w, r, "title", recipeSortKeys, models.Recipe{})
+	if !ok {
+		return
 	}
+	listings, err := s.db.ListRecipes(r.URL.Query().Get("archived") == "true")
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+	listings, next := paging.Page(listings, recipeEntry(params.sort.Key), params.sort, params.after, params.limit)
+	recipes := make([]models.Recipe, len(listings))
+	for i, listing := range listings {
+		recipes[i] = convert(listing.Recipe)
+	}
+
+	writePage(w, r, recipes, next, params, nil)
 }
 
 func (s *WebServer) recipeHandler(w http.ResponseWriter, r *http.Request) {
+	convert, err := unitConverter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+		return
+	}
+
 	recipe, err := s.db.GetRecipe(r.PathValue("recipeId"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, convert(recipe))
+}
+
+func (s *WebServer) scaledRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	convert, err := unitConverter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipe); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	recipe, err := s.db.GetRecipe(r.PathValue("recipeId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
 	}
+
+	var factor float64
+	if servings := r.URL.Query().Get("servings"); servings != "" {
+		n, err := strconv.ParseFloat(servings, 64)
+		if err != nil || n <= 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid servings: "+servings)
+			return
+		}
+		factor, err = scaling.FactorForServings(recipe, n)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, err.Error())
+			return
+		}
+	} else if f := r.URL.Query().Get("factor"); f != "" {
+		factor, err = strconv.ParseFloat(f, 64)
+		if err != nil || factor <= 0 || math.IsNaN(factor) || math.IsInf(factor, 0) {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "Invalid factor: "+f)
+			return
+		}
+	} else {
+		writeError(w, r, http.StatusBadRequest, codeInvalid, "Either servings or factor must be set")
+		return
+	}
+
+	writeJSON(w, convert(scaling.ScaleRecipe(recipe, factor)))
 }
 
 func (s *WebServer) recipeHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	recipe, err := s.db.GetRecipeHistory(r.PathValue("recipeId"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, recipe)
+}
+
+func (s *WebServer) recipeDiffHandler(w http.ResponseWriter, r *http.Request) {
+	diff, err := s.db.DiffRecipeVersions(r.PathValue("recipeId"), r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, diff)
+}
+
+func (s *WebServer) recipeVersionHandler(w http.ResponseWriter, r *http.Request) {
+	recipe, err := s.db.GetRecipeVersion(r.PathValue("recipeId"), r.PathValue("hash"))
+	if err != nil {
+		writeDbError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipe); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	writeJSON(w, recipe)
+}
+
+func (s *WebServer) restoreRecipeVersionHandler(w http.ResponseWriter, r *http.Request) {
+	recipe, err := s.db.RestoreRecipeVersion(r.PathValue("recipeId"), r.PathValue("hash"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
 	}
+
+	writeJSON(w, recipe)
 }
 
 func (s *WebServer) newRecipeHandler(w http.ResponseWriter, r *http.Request) {
 	var recipe models.Recipe
-	if err := json.NewDecoder(r.Body).Decode(&recipe); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &recipe) {
+		return
+	}
+	if errs := validation.ValidateRecipe(recipe); len(errs) > 0 {
+		writeValidationError(w, r, errs)
 		return
 	}
 
-	err := s.db.AddOrUpdateRecipe(recipe, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	// Existing recipes are only overwritten when the client explicitly asks for an update
+	var err error
+	if r.URL.Query().Get("update") == "true" {
+		if recipe.Id == "" {
+			writeError(w, r, http.StatusBadRequest, codeInvalid, "id must be set when updating a recipe")
+			return
+		}
+		recipe, err = s.db.UpdateRecipe(recipe, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	} else {
+		recipe, err = s.db.CreateRecipe(recipe, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipe); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	writeJSON(w, recipe)
+}
+
+func (s *WebServer) deleteRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.db.DeleteRecipe(r.PathValue("recipeId"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
 	}
+
+	w.WriteHeader(http.StatusNoContent)
+	slog.Info("Deleted recipe", "recipeId", r.PathValue("recipeId"))
+}
+
+func (s *WebServer) renameRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	newId := r.URL.Query().Get("newId")
+	if errs := validation.ValidateId("newId", newId); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	err := s.db.RenameRecipe(r.PathValue("recipeId"), newId, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	recipe, err := s.db.GetRecipe(newId)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, recipe)
+}
+
+// forkRecipeHandler creates a new recipe from the current version of the recipe. The new recipe
+// is named with the optional newId and title query parameters.
+func (s *WebServer) forkRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	newId := r.URL.Query().Get("newId")
+	if newId != "" {
+		if errs := validation.ValidateId("newId", newId); len(errs) > 0 {
+			writeValidationError(w, r, errs)
+			return
+		}
+	}
+
+	fork, err := s.db.ForkRecipe(r.PathValue("recipeId"), newId, r.URL.Query().Get("title"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, fork)
+}
+
+func (s *WebServer) recipeFamilyHandler(w http.ResponseWriter, r *http.Request) {
+	family, err := s.db.GetRecipeFamily(r.PathValue("recipeId"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, family)
+}
+
+func (s *WebServer) archiveRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	s.setRecipeArchived(w, r, true)
+}
+
+func (s *WebServer) unarchiveRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	s.setRecipeArchived(w, r, false)
+}
+
+func (s *WebServer) setRecipeArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	err := s.db.SetRecipeArchived(r.PathValue("recipeId"), archived, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *WebServer) dbPushHandler(w http.ResponseWriter, r *http.Request) {
 	err := s.db.Push()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
 		return
 	}
 }
@@ -172,63 +461,155 @@ func (s *WebServer) dbPushHandler(w http.ResponseWriter, r *http.Request) {
 func (s *WebServer) dbPullHandler(w http.ResponseWriter, r *http.Request) {
 	err := s.db.Pull()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
+		return
+	}
+}
+
+func (s *WebServer) dbStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := s.db.Status()
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+func (s *WebServer) dbReindexHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.db.RebuildIndex()
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+}
+
+func (s *WebServer) recipeDriftHandler(w http.ResponseWriter, r *http.Request) {
+	drift, err := s.db.GetRecipeDrift(r.PathValue("recipeId"))
+	if err != nil {
+		writeDbError(w, r, err)
 		return
 	}
+
+	writeJSON(w, drift)
 }
 
+// recipeLogsHandler lists the logs of a recipe. With ?stats=true, the logs are returned
+// together with rating stats, as {"logs": [...], "stats": {...}}. Logs are sorted and paged
+// like recipes, by created, modified or rating.
 func (s *WebServer) recipeLogsHandler(w http.ResponseWriter, r *http.Request) {
+	params, ok := readListParams(w, r, "created", logSortKeys, models.RecipeLog{})
+	if !ok {
+		return
+	}
 	recipeLogs, err := s.db.GetRecipeLogs(r.PathValue("recipeId"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
 		return
 	}
+	page, next := paging.Page(recipeLogs, logEntry(params.sort.Key), params.sort, params.after, params.limit)
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipeLogs); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if r.URL.Query().Get("stats") == "true" {
+		// The stats are of all the logs, not just the page
+		writePage(w, r, page, next, params, func(logs []any) any {
+			return recipeLogsWithStats{Logs: logs, Stats: ratings.Stats(recipeLogs)}
+		})
+		return
 	}
+	writePage(w, r, page, next, params, nil)
+}
+
+type recipeLogsWithStats struct {
+	// Logs are the logs of the page, with the fields asked for
+	Logs  any                `json:"logs"`
+	Stats models.RatingStats `json:"stats"`
 }
 
 func (s *WebServer) recipeLogHandler(w http.ResponseWriter, r *http.Request) {
 	recipeLog, err := s.db.GetRecipeLog(r.PathValue("recipeId"), r.PathValue("logId"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipeLog); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	writeJSON(w, recipeLog)
 }
 
 func (s *WebServer) newRecipeLogHandler(w http.ResponseWriter, r *http.Request) {
 	var recipe models.RecipeLog
-	if err := json.NewDecoder(r.Body).Decode(&recipe); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &recipe) {
+		return
+	}
+	if recipe.RecipeId == "" {
+		recipe.RecipeId = r.PathValue("recipeId")
+	}
+	errs := validation.ValidateRecipeLog(recipe)
+	if recipe.RecipeId != r.PathValue("recipeId") {
+		errs = append(errs, validation.FieldError{Field: "recipeId", Message: "must match the recipe in the URL"})
+	}
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	recipe, err := s.db.AddRecipeLog(recipe, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, recipe)
+}
+
+func (s *WebServer) updateRecipeLogHandler(w http.ResponseWriter, r *http.Request) {
+	var rlog models.RecipeLog
+	if !decodeJSON(w, r, &rlog) {
+		return
+	}
+	rlog.RecipeId = r.PathValue("recipeId")
+	rlog.Id = r.PathValue("logId")
+	if errs := validation.ValidateRecipeLog(rlog); len(errs) > 0 {
+		writeValidationError(w, r, errs)
 		return
 	}
 
-	err := s.db.AddRecipeLog(recipe, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	// The client passes the commit it last saw in If-Match, to detect concurrent edits
+	lastCommit := strings.Trim(r.Header.Get("If-Match"), `"`)
+
+	err := s.db.UpdateRecipeLog(rlog, lastCommit, r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(recipe); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	updated, err := s.db.GetRecipeLog(rlog.RecipeId, rlog.Id)
+	if err != nil {
+		writeDbError(w, r, err)
+		return
 	}
+
+	writeJSON(w, updated)
 }
 
 func (s *WebServer) deleteRecipeLogHandler(w http.ResponseWriter, r *http.Request) {
 	err := s.db.DeleteRecipeLog(r.PathValue("recipeId"), r.PathValue("logId"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDbError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent) // 204 No Content
 	slog.Info("Deleted recipe log", "recipeId", r.PathValue("recipeId"), "logId", r.PathValue("logId"))
 }
+
+// promoteRecipeLogHandler makes the actual ingredients of the log the ingredients of the recipe,
+// and returns the updated recipe
+func (s *WebServer) promoteRecipeLogHandler(w http.ResponseWriter, r *http.Request) {
+	recipe, err := s.db.PromoteRecipeLog(r.PathValue("recipeId"), r.PathValue("logId"), r.URL.Query().Get("commitMessage"), r.URL.Query().Get("author"))
+	if err != nil {
+		writeDbError(w, r, err)
+		return
+	}
+
+	writeJSON(w, recipe)
+}